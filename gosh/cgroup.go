@@ -0,0 +1,282 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * A cgroup v2 subtree to run a command in.  Set as Opts.Cgroup.
+ *
+ * When the command is started, a fresh cgroup is created under Parent, the limits
+ * are written to it, and the command is started directly inside it (so there's no
+ * window where it runs unlimited).  When the command exits, whatever it left behind in
+ * the cgroup is killed, its stats are collected (see RunningCommand.GetCgroupStats()),
+ * and the cgroup is removed.  If something in it can't be killed within a few seconds
+ * (e.g. it's stuck in uninterruptible sleep), the command is considered done anyway,
+ * and the cgroup is left behind, with whatever is stuck in it.
+ *
+ * Limits need their controllers enabled in Parent's cgroup.subtree_control, and gosh
+ * will try to enable any that aren't.  The kernel won't allow that in a cgroup that
+ * has processes in it (other than the root), so Parent should usually be a cgroup
+ * delegated for the purpose rather than the one this process is in.
+ */
+type Cgroup struct {
+	/**
+	 * The cgroup to create the command's cgroup in, as a path to its directory in the
+	 * cgroup2 filesystem.  If not provided, it's the cgroup this process is in.
+	 */
+	Parent string
+
+	/**
+	 * Memory limit, in bytes, as memory.max.  A command that's killed by the OOM killer
+	 * is reported by Run() as OutOfMemory.
+	 */
+	MemoryMax *uint64
+
+	/**
+	 * CPU limit, in CPUs' worth of time, as cpu.max; e.g. 0.5 for half of one CPU.
+	 * Zero is unlimited.
+	 */
+	CPUs float64
+
+	/** Number of processes (and threads), as pids.max. */
+	PidsMax *uint64
+}
+
+/**
+ * What a command's cgroup recorded by the time it exited.
+ */
+type CgroupStats struct {
+	/** Peak memory usage, in bytes, as memory.peak.  Zero if the kernel doesn't report it. */
+	MemoryPeak uint64
+
+	/** Number of processes killed by the OOM killer, as oom_kill in memory.events. */
+	OomKills uint64
+
+	/** CPU time used, as usage_usec in cpu.stat. */
+	CPUTime time.Duration
+}
+
+var cgroupCount int64
+
+const cgroupCPUPeriod = 100000
+
+/**
+ * Creates a cgroup for a command and applies the limits.  Returns the path to it.
+ */
+func (cg *Cgroup) create() (string, error) {
+	parent := cg.Parent
+	if parent == "" {
+		var err error
+		if parent, err = ownCgroup(); err != nil {
+			return "", err
+		}
+	}
+	var controllers []string
+	if cg.MemoryMax != nil {
+		controllers = append(controllers, "memory")
+	}
+	if cg.CPUs != 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if cg.PidsMax != nil {
+		controllers = append(controllers, "pids")
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(parent, fmt.Sprintf("gosh-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupCount, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("cgroup: %s", err)
+	}
+	set := func(file string, value string) error {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			return fmt.Errorf("cgroup: setting %s: %s", file, err)
+		}
+		return nil
+	}
+	var err error
+	if cg.MemoryMax != nil {
+		err = set("memory.max", strconv.FormatUint(*cg.MemoryMax, 10))
+	}
+	if err == nil && cg.CPUs != 0 {
+		err = set("cpu.max", fmt.Sprintf("%d %d", int64(cg.CPUs*cgroupCPUPeriod), cgroupCPUPeriod))
+	}
+	if err == nil && cg.PidsMax != nil {
+		err = set("pids.max", strconv.FormatUint(*cg.PidsMax, 10))
+	}
+	if err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+/**
+ * Creates the command's cgroup and arranges for the process to be started in it.
+ * Returns the cgroup's directory, open, which must be kept open until the process is started.
+ */
+func (cmd *RunningCommand) enterCgroup() (*os.File, error) {
+	dir, err := cmd.cgroup.create()
+	if err != nil {
+		return nil, err
+	}
+	cgroupFile, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("cgroup: %s", err)
+	}
+	sysProcAttr(cmd.cmd).UseCgroupFD = true
+	sysProcAttr(cmd.cmd).CgroupFD = int(cgroupFile.Fd())
+	cmd.cgroupDir = dir
+	return cgroupFile, nil
+}
+
+/**
+ * Finds the directory of the cgroup this process is in, from /proc.
+ */
+func ownCgroup() (string, error) {
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("cgroup: %s", err)
+	}
+	path := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = line[3:]
+		}
+	}
+	if path == "" {
+		return "", fmt.Errorf("cgroup: this process is not in a cgroup v2 hierarchy")
+	}
+
+	mountinfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("cgroup: %s", err)
+	}
+	defer mountinfo.Close()
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// "id parent dev root mountpoint options [optional...] - fstype source superoptions"
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" && len(fields) > 4 {
+				rel, err := filepath.Rel(fields[3], path)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					break
+				}
+				return filepath.Join(fields[4], rel), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("cgroup: no cgroup2 filesystem is mounted")
+}
+
+func enableControllers(parent string, controllers []string) error {
+	if len(controllers) == 0 {
+		return nil
+	}
+	enabled, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("cgroup: %s", err)
+	}
+	for _, controller := range controllers {
+		if hasField(string(enabled), controller) {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			return fmt.Errorf("cgroup: enabling %s controller in %s: %s", controller, parent, err)
+		}
+	}
+	return nil
+}
+
+func hasField(s string, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Reads the stats of a finished command's cgroup, kills anything left in it, and removes it.
+ */
+func releaseCgroup(dir string) *CgroupStats {
+	stats := &CgroupStats{}
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "memory.peak")); err == nil {
+		stats.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	}
+	stats.OomKills = cgroupKey(dir, "memory.events", "oom_kill")
+	stats.CPUTime = time.Duration(cgroupKey(dir, "cpu.stat", "usage_usec")) * time.Microsecond
+
+	// cgroup.kill is only in newer kernels; without it, anything left behind keeps the cgroup around.
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644); err == nil {
+		// a process stuck in uninterruptible sleep can't die until it wakes, and we won't wait forever.
+		deadline := time.Now().Add(cgroupReleaseTimeout)
+		for cgroupKey(dir, "cgroup.events", "populated") != 0 {
+			if time.Now().After(deadline) {
+				return stats
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	os.Remove(dir)
+	return stats
+}
+
+/** How long to wait for a cgroup to empty out before giving up and leaving it behind. */
+var cgroupReleaseTimeout = 5 * time.Second
+
+/**
+ * Reads one value from a flat-keyed cgroup file like memory.events.  Missing is zero.
+ */
+func cgroupKey(dir string, file string, key string) uint64 {
+	content, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseUint(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+/**
+ * Returns what the command's cgroup recorded, or nil if it wasn't run in one
+ * (see Opts.Cgroup).  Waits for the command to exit if it has not already.
+ */
+func (cmd *RunningCommand) GetCgroupStats() *CgroupStats {
+	if !cmd.IsDone() {
+		cmd.Wait()
+	}
+	return cmd.cgroupStats
+}
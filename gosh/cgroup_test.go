@@ -0,0 +1,156 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns the cgroup commands will be created in, and the controllers available there;
+ * or skips the test if there isn't one we can use.
+ */
+func requireCgroup(t *testing.T) (string, string) {
+	if os.Getuid() != 0 {
+		t.Skip("creating cgroups requires running as root")
+	}
+	parent, err := ownCgroup()
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	controllers, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	return parent, string(controllers)
+}
+
+func TestCgroupPlacement(t *testing.T) {
+	parent, _ := requireCgroup(t)
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("cat")("/proc/self/cgroup")(Opts{Cgroup: &Cgroup{}})
+	out := cmd.Output()
+	var path string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = line[3:]
+		}
+	}
+	assert.True(strings.HasPrefix(filepath.Base(path), "gosh-"))
+
+	// and it's gone afterwards.
+	matches, _ := filepath.Glob(filepath.Join(parent, filepath.Base(path)))
+	assert.Equal(
+		0,
+		len(matches),
+	)
+}
+
+func TestCgroupStats(t *testing.T) {
+	requireCgroup(t)
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("true")(Opts{Cgroup: &Cgroup{}}).Start()
+	assert.NotNil(cmd.GetCgroupStats())
+	assert.Equal(
+		uint64(0),
+		cmd.GetCgroupStats().OomKills,
+	)
+
+	cmd = Sh("true").Start()
+	assert.Nil(cmd.GetCgroupStats())
+}
+
+func TestCgroupKillsLeftovers(t *testing.T) {
+	requireCgroup(t)
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("sh")("-c", "sleep 100 >/dev/null & echo $!")(Opts{Cgroup: &Cgroup{}})
+	start := time.Now()
+	pid := strings.TrimSpace(cmd.Output())
+	assert.True(time.Since(start) < 5*time.Second)
+
+	// the orphan is killed; nobody may reap it, but it won't still be sleeping.
+	stat, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+	if err == nil {
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		assert.Equal(
+			"Z",
+			fields[0],
+		)
+	}
+}
+
+func TestCgroupUnavailableController(t *testing.T) {
+	_, controllers := requireCgroup(t)
+	if hasField(controllers, "pids") {
+		t.Skip("pids controller is available")
+	}
+	assert := assrt.NewAssert(t)
+
+	err := Sh("true")(Opts{Cgroup: &Cgroup{PidsMax: Limit(10)}}).RunE()
+	assert.NotNil(err)
+	_, ok := err.(CommandStartError)
+	assert.True(ok)
+	assert.True(strings.Contains(err.Error(), "enabling pids controller"))
+}
+
+func TestCgroupOutOfMemory(t *testing.T) {
+	_, controllers := requireCgroup(t)
+	if !hasField(controllers, "memory") {
+		t.Skip("memory controller is not available")
+	}
+	assert := assrt.NewAssert(t)
+
+	// a shell variable is held in memory; make one much bigger than the limit.
+	err := Sh("sh")("-c", "x=$(head -c 200000000 /dev/zero | tr '\\0' a); echo ${#x}")(Opts{
+		Cgroup: &Cgroup{MemoryMax: Limit(20 << 20)},
+		Out:    ioutil.Discard,
+	}).RunE()
+	oom, ok := err.(OutOfMemory)
+	assert.True(ok)
+	assert.Equal(
+		uint64(20<<20),
+		oom.Limit,
+	)
+	assert.True(oom.OomKills > 0)
+}
+
+func TestCgroupReleaseGivesUp(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// a stand-in for a cgroup whose last process won't die.
+	dir, err := ioutil.TempDir("", "gosh-cgroup-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "cgroup.kill"), nil, 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "cgroup.events"), []byte("populated 1\nfrozen 0\n"), 0644))
+
+	defer func(timeout time.Duration) { cgroupReleaseTimeout = timeout }(cgroupReleaseTimeout)
+	cgroupReleaseTimeout = 100 * time.Millisecond
+	start := time.Now()
+	stats := releaseCgroup(dir)
+	assert.NotNil(stats)
+	assert.True(time.Since(start) < time.Second)
+	_, err = os.Stat(dir)
+	assert.Nil(err)
+}
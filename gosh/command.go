@@ -16,6 +16,8 @@ package gosh
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"sync"
 	"sync/atomic"
@@ -51,6 +53,15 @@ const (
 	 * code may not be reliably known.
 	 */
 	PANICKED
+
+	/**
+	 * 'Cancelled' is the state of a command that was stopped because its Context was done,
+	 * or because it ran past its timeout.
+	 *
+	 * The command was signalled (and killed, if it didn't exit in time), and its exit code was
+	 * observed, but that exit code says more about how we stopped it than about the command.
+	 */
+	CANCELLED
 )
 
 func NewRunningCommand(cmd *exec.Cmd) *RunningCommand {
@@ -58,6 +69,7 @@ func NewRunningCommand(cmd *exec.Cmd) *RunningCommand {
 		cmd:      cmd,
 		state:    UNSTARTED,
 		exitCh:   make(chan bool),
+		reapCh:   make(chan bool),
 		exitCode: -1,
 	}
 }
@@ -79,14 +91,85 @@ type RunningCommand struct {
 	/** Wait for this to close in order to wait for the process to return. */
 	exitCh chan bool
 
+	/** Closed as soon as the process itself has been reaped, which may be before exitCh
+	 * closes (e.g. if it's a pipeline stage still waiting for its upstream). */
+	reapCh chan bool
+
+	/** Set (under the mutex) once the process has been reaped, after which its pid must
+	 * never be signalled again, since it may already belong to somebody else. */
+	reaped bool
+
 	/** Exit code if we're state==FINISHED and exit codes are possible on this platform, or
 	 * -1 if we're not there yet.  Will not change after exitCh has closed. */
 	exitCode int
 
 	/** Functions to call back when the command has exited. */
 	exitListeners []func(*RunningCommand)
+
+	/** If this command is a stage in a pipeline, the stage piping into it.  This command
+	 * is not considered done until its upstream is done as well. */
+	upstream *RunningCommand
+
+	/** If set, the command will be stopped when this context is done. */
+	ctx *Context
+
+	/** If nonzero, the command will be stopped when it's been running this long. */
+	timeout time.Duration
+
+	/** How long to wait after asking a timed out command to stop before killing it. */
+	timeoutGrace time.Duration
+
+	/** Set when the command's context is done (or its timeout has passed) and we've begun stopping it. */
+	cancelled error
+
+	/** When the process was started, and when it was reaped. */
+	startTime, reapTime time.Time
+
+	/** If set, the command will be run on a pseudo-terminal. */
+	tty *Tty
+
+	/** The master side of the command's pty, and the streams we pump through it. */
+	pty    *os.File
+	ttyIn  io.Reader
+	ttyOut io.Writer
+
+	/** Closed when all output has been pumped out of the pty. */
+	ttyDone chan bool
+
+	/** Descriptors only the child needs, which we close once it has them. */
+	closeAfterStart []io.Closer
+
+	/** Things to close once the process has exited, e.g. inputs that might still be waiting to be read. */
+	closeAfterExit []io.Closer
+
+	/** Sinks decoding the output, whose failures count as the command's. */
+	decoders []*RecordWriter
+
+	/** The state of the process as of when it was reaped. */
+	processState *os.ProcessState
+
+	/** If set, keeps the end of whatever the command writes to stderr. */
+	stderrTail *tailWriter
+
+	/** If set, resource limits to apply to the process. */
+	rlimits *Rlimits
+
+	/** If set, the umask to start the process with. */
+	umask *os.FileMode
+
+	/** If set, a cgroup to create and start the process in. */
+	cgroup *Cgroup
+
+	/** Path to the cgroup created for the process, until it's removed. */
+	cgroupDir string
+
+	/** What the cgroup recorded, once the process has exited. */
+	cgroupStats *CgroupStats
 }
 
+/** Placeholder for cmd.cancelled when it's the timeout that stops a command. */
+var errTimeout = fmt.Errorf("timeout exceeded")
+
 func (cmd *RunningCommand) State() int32 {
 	return atomic.LoadInt32(&cmd.state)
 }
@@ -100,13 +183,13 @@ func (cmd *RunningCommand) IsRunning() bool {
 /** Returns true if the command has ever been started (including if the command is already finished). */
 func (cmd *RunningCommand) IsStarted() bool {
 	state := cmd.State()
-	return state == RUNNING || state == FINISHED || state == PANICKED
+	return state == RUNNING || state == FINISHED || state == PANICKED || state == CANCELLED
 }
 
-/** Returns true if the command is finished (either gracefully, or with internal errors). */
+/** Returns true if the command is finished (either gracefully, with internal errors, or by cancellation). */
 func (cmd *RunningCommand) IsDone() bool {
 	state := cmd.State()
-	return state == FINISHED || state == PANICKED
+	return state == FINISHED || state == PANICKED || state == CANCELLED
 }
 
 /** Returns true if the command is finished gracefully.  (A nonzero exit code may still be set.) */
@@ -115,6 +198,12 @@ func (cmd *RunningCommand) IsFinishedGracefully() bool {
 	return state == FINISHED
 }
 
+/**
+ * Starts execution of the command.  If the command cannot be started, a panic of
+ * type CommandStartError will be emitted; use StartE() to get it as an error instead.
+ *
+ * Starting a command that has already been started has no effect.
+ */
 func (cmd *RunningCommand) Start() *RunningCommand {
 	if err := cmd.startCalmly(); err != nil {
 		panic(err)
@@ -122,6 +211,14 @@ func (cmd *RunningCommand) Start() *RunningCommand {
 	return cmd
 }
 
+/**
+ * Same as Start(), but returns a CommandStartError instead of panicking if the
+ * command cannot be started.
+ */
+func (cmd *RunningCommand) StartE() error {
+	return cmd.startCalmly()
+}
+
 func (cmd *RunningCommand) startCalmly() error {
 	cmd.mutex.Lock()
 	defer cmd.mutex.Unlock()
@@ -131,15 +228,137 @@ func (cmd *RunningCommand) startCalmly() error {
 	}
 
 	atomic.StoreInt32(&cmd.state, RUNNING)
-	if err := cmd.cmd.Start(); err != nil {
-		cmd.finalState(CommandStartError{cause: err})
+	if cmd.tty != nil {
+		if err := cmd.attachTty(); err != nil {
+			cmd.finalState(cmd.startError(err))
+			return cmd.err
+		}
+	}
+	cmd.startTime = time.Now()
+	err := cmd.startProcess()
+	for _, c := range cmd.closeAfterStart {
+		c.Close()
+	}
+	if err != nil {
+		if cmd.pty != nil {
+			cmd.pty.Close()
+		}
+		for _, c := range cmd.closeAfterExit {
+			c.Close()
+		}
+		cmd.finalState(cmd.startError(err))
 		return cmd.err
 	}
+	if cmd.pty != nil {
+		cmd.startTtyPumps()
+	}
 
 	go cmd.waitAndHandleExit()
+	if cmd.ctx != nil {
+		go cmd.watchContext()
+	}
+	if cmd.timeout > 0 {
+		go cmd.watchTimeout()
+	}
 	return nil
 }
 
+/**
+ * Starts the process, with any cgroup, umask, and rlimits it needs.
+ */
+func (cmd *RunningCommand) startProcess() error {
+	var prelude []string
+	if cmd.umask != nil {
+		prelude = append(prelude, fmt.Sprintf("umask %04o", uint32(*cmd.umask)))
+	}
+	if cmd.rlimits != nil {
+		ulimits, err := cmd.rlimits.ulimits(probeTrampolineShell())
+		if err != nil {
+			return err
+		}
+		prelude = append(prelude, ulimits...)
+	}
+	if len(prelude) > 0 {
+		defer cmd.trampoline(prelude)()
+	}
+	if cmd.cgroup != nil {
+		cgroupFile, err := cmd.enterCgroup()
+		if err != nil {
+			return err
+		}
+		defer cgroupFile.Close()
+	}
+	err := cmd.cmd.Start()
+	if err != nil && cmd.cgroupDir != "" {
+		os.Remove(cmd.cgroupDir)
+		cmd.cgroupDir = ""
+	}
+	return err
+}
+
+/**
+ * Wraps an error from starting the command, noting what it was supposed to be started as.
+ */
+func (cmd *RunningCommand) startError(err error) CommandStartError {
+	startErr := CommandStartError{cause: err, umask: cmd.umask}
+	if cmd.cmd.SysProcAttr != nil {
+		startErr.credential = cmd.cmd.SysProcAttr.Credential
+	}
+	return startErr
+}
+
+/**
+ * Waits for either the command to exit or its context to be done, and in the latter
+ * case, stops the command.
+ */
+func (cmd *RunningCommand) watchContext() {
+	select {
+	case <-cmd.exitCh:
+		return
+	case <-cmd.ctx.Done():
+	}
+
+	sig := cmd.ctx.Signal
+	if sig == nil {
+		sig = os.Kill
+	}
+	cmd.cancel(cmd.ctx.Err(), sig, cmd.ctx.Grace)
+}
+
+/**
+ * Waits for either the command to exit or its timeout to pass, and in the latter
+ * case, stops the command.
+ */
+func (cmd *RunningCommand) watchTimeout() {
+	select {
+	case <-cmd.exitCh:
+		return
+	case <-time.After(cmd.timeout):
+	}
+
+	if cmd.timeoutGrace > 0 {
+		cmd.cancel(errTimeout, syscall.SIGTERM, cmd.timeoutGrace)
+	} else {
+		cmd.cancel(errTimeout, os.Kill, 0)
+	}
+}
+
+/**
+ * Records why the command is being stopped, then stops it.  If the command is
+ * already being stopped for some other reason, does nothing.
+ */
+func (cmd *RunningCommand) cancel(reason error, sig os.Signal, grace time.Duration) {
+	cmd.mutex.Lock()
+	if !cmd.IsRunning() || cmd.cancelled != nil {
+		cmd.mutex.Unlock()
+		return
+	}
+	cmd.cancelled = reason
+	cmd.mutex.Unlock()
+
+	cmd.terminate(sig, grace)
+}
+
 func (cmd *RunningCommand) waitAndHandleExit() {
 	exitCode := -1
 	var err error
@@ -147,13 +366,49 @@ func (cmd *RunningCommand) waitAndHandleExit() {
 		exitCode, err = cmd.waitTry()
 	}
 
+	// If the command leads its own process group, it isn't gone until the whole group is.
+	if cmd.leadsGroup() {
+		waitForGroup(cmd.cmd.Process.Pid)
+	}
+
+	// The cgroup is ours alone, so clear out whatever's left in it.
+	var cgroupStats *CgroupStats
+	if cmd.cgroupDir != "" {
+		cgroupStats = releaseCgroup(cmd.cgroupDir)
+	}
+
+	cmd.mutex.Lock()
+	cmd.reaped = true
+	cmd.reapTime = time.Now()
+	cmd.cgroupStats = cgroupStats
+	close(cmd.reapCh)
+	cmd.mutex.Unlock()
+
 	// Do one last Wait for good ol' times sake.  And to use the Cmd.closeDescriptors feature.
 	cmd.cmd.Wait()
 
+	// Output from a pty isn't copied by the Cmd, so Wait doesn't cover it.
+	if cmd.ttyDone != nil {
+		<-cmd.ttyDone
+		cmd.pty.Close()
+	}
+
+	for _, c := range cmd.closeAfterExit {
+		c.Close()
+	}
+
+	// A pipeline isn't done until all of it is done.
+	if cmd.upstream != nil {
+		cmd.upstream.Wait()
+	}
+
 	cmd.mutex.Lock()
 	defer cmd.mutex.Unlock()
 
 	cmd.exitCode = exitCode
+	if err != nil {
+		err = CommandMonitorError{cause: err}
+	}
 	cmd.finalState(err)
 }
 
@@ -176,11 +431,13 @@ func (cmd *RunningCommand) waitTry() (int, error) {
 
 	if waitStatus, ok := processState.Sys().(syscall.WaitStatus); ok {
 		if waitStatus.Exited() {
+			cmd.processState = processState
 			return waitStatus.ExitStatus(), nil
 		} else if waitStatus.Signaled() {
 			// In bash, when a processs ends from a signal, the $? variable is set to 128+SIG.
 			// We follow that same convention here.
 			// So, a process terminated by ctrl-C returns 130.  A script that died to kill-9 returns 137.
+			cmd.processState = processState
 			return int(waitStatus.Signal()) + 128, nil
 		} else {
 			// This should be more or less unreachable.
@@ -202,7 +459,19 @@ func (cmd *RunningCommand) finalState(err error) {
 	// must hold cmd.mutex before calling this
 	// golang is an epic troll: claims to be best buddy for concurrent code, SYNC PACKAGE DOES NOT HAVE REENTRANT LOCKS
 	if cmd.IsRunning() {
-		if err == nil {
+		if err == nil && cmd.cancelled == errTimeout {
+			cmd.err = TimeoutExceeded{
+				cmdname: cmd.cmd.Args[0],
+				args:    cmd.cmd.Args[1:],
+				timeout: cmd.timeout,
+				elapsed: cmd.reapTime.Sub(cmd.startTime),
+				code:    cmd.exitCode,
+			}
+			atomic.StoreInt32(&cmd.state, CANCELLED)
+		} else if err == nil && cmd.cancelled != nil {
+			cmd.err = CommandCancelled{cause: cmd.cancelled, code: cmd.exitCode}
+			atomic.StoreInt32(&cmd.state, CANCELLED)
+		} else if err == nil {
 			atomic.StoreInt32(&cmd.state, FINISHED)
 		} else {
 			cmd.err = err
@@ -230,6 +499,89 @@ func (cmd *RunningCommand) Pid() int {
 	}
 }
 
+/**
+ * Returns true if the process was started as the leader of its own process group
+ * (or session), e.g. by Opts.ProcessGroup.
+ */
+func (cmd *RunningCommand) leadsGroup() bool {
+	attr := cmd.cmd.SysProcAttr
+	return attr != nil && (attr.Setsid || (attr.Setpgid && attr.Pgid == 0))
+}
+
+/**
+ * Sends a signal to the process.  If the process leads its own process group, the
+ * signal is sent to the whole group.
+ *
+ * Returns a CommandNotRunning error without sending anything if the command hasn't
+ * been started yet, or if the process (or its whole group) has already exited and
+ * been reaped.  (Since pids are recycled, signalling a reaped process could hit some
+ * other process.)
+ *
+ * Sending a signal doesn't wait for anything; use Wait() afterwards to wait for the
+ * command to exit, if the signal is expected to cause that.
+ */
+func (cmd *RunningCommand) Signal(sig os.Signal) error {
+	cmd.mutex.Lock()
+	defer cmd.mutex.Unlock()
+
+	if !cmd.IsRunning() {
+		return CommandNotRunning{state: cmd.State()}
+	}
+	if cmd.reaped {
+		return CommandNotRunning{state: FINISHED}
+	}
+	if cmd.leadsGroup() {
+		if sysSig, ok := sig.(syscall.Signal); ok {
+			return syscall.Kill(-cmd.cmd.Process.Pid, sysSig)
+		}
+	}
+	return cmd.cmd.Process.Signal(sig)
+}
+
+/**
+ * Sends SIGTERM to the process, then if it hasn't exited after the grace period,
+ * kills it.  Returns once the process has exited or the kill has been sent.
+ *
+ * Terminating a command that's already done has no effect, and returns nil.
+ * Terminating a command that hasn't been started returns a CommandNotRunning error.
+ */
+func (cmd *RunningCommand) Terminate(grace time.Duration) error {
+	return cmd.terminate(syscall.SIGTERM, grace)
+}
+
+/**
+ * Sends SIGKILL to the process.  Use Wait() afterwards to wait for the command to
+ * be done.
+ *
+ * Killing a command that's already done has no effect, and returns nil.
+ * Killing a command that hasn't been started returns a CommandNotRunning error.
+ */
+func (cmd *RunningCommand) Kill() error {
+	return cmd.terminate(os.Kill, 0)
+}
+
+/**
+ * Sends a signal to the process, then if it hasn't exited after the grace period, kills it.
+ */
+func (cmd *RunningCommand) terminate(sig os.Signal, grace time.Duration) error {
+	if err := cmd.Signal(sig); err != nil {
+		if cmd.State() == UNSTARTED {
+			return err
+		}
+		return nil
+	}
+	if sig == os.Kill {
+		return nil
+	}
+	select {
+	case <-cmd.reapCh:
+		return nil
+	case <-time.After(grace):
+	}
+	cmd.Signal(os.Kill)
+	return nil
+}
+
 /**
  * Add a function to be called when this command completes.
  *
@@ -307,6 +659,51 @@ func (cmd *RunningCommand) GetExitCode() int {
 	return cmd.exitCode
 }
 
+/**
+ * Waits for the command to exit if it has not already, then returns the signal that
+ * terminated the process, or zero if it exited on its own (or its exit was never observed).
+ */
+func (cmd *RunningCommand) GetSignal() syscall.Signal {
+	if !cmd.IsDone() {
+		cmd.Wait()
+	}
+	if cmd.processState == nil {
+		return 0
+	}
+	if waitStatus := cmd.processState.Sys().(syscall.WaitStatus); waitStatus.Signaled() {
+		return waitStatus.Signal()
+	}
+	return 0
+}
+
+/**
+ * Waits for the command to exit if it has not already, then returns how long it ran,
+ * from when it was started until it was reaped.  Returns zero if it never started.
+ */
+func (cmd *RunningCommand) GetDuration() time.Duration {
+	if !cmd.IsDone() {
+		cmd.Wait()
+	}
+	if cmd.reapTime.IsZero() {
+		return 0
+	}
+	return cmd.reapTime.Sub(cmd.startTime)
+}
+
+/**
+ * Waits for the command to exit if it has not already, then returns the error that
+ * put the command into the PANICKED or CANCELLED state, or nil if it finished gracefully.
+ *
+ * The error will be a CommandStartError, a CommandMonitorError, a CommandCancelled,
+ * or a TimeoutExceeded.
+ */
+func (cmd *RunningCommand) GetError() error {
+	if !cmd.IsDone() {
+		cmd.Wait()
+	}
+	return cmd.err
+}
+
 /**
  * Waits for the command to exit if it has not already, or for the specified duration,
  * then either returns the exit code, or -1 if the duration expired and the command
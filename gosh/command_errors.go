@@ -16,6 +16,10 @@ package gosh
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
 )
 
 /**
@@ -23,13 +27,37 @@ import (
  */
 type CommandStartError struct {
 	cause error
+
+	/** The credentials the command was to be started with, if any were requested. */
+	credential *syscall.Credential
+
+	/** The umask the command was to be started with, if one was requested. */
+	umask *os.FileMode
 }
 
 func (err CommandStartError) Cause() error {
 	return err.cause
 }
 
+/** Returns the credentials the command was to be started with, or nil if none were requested. */
+func (err CommandStartError) Credential() *syscall.Credential {
+	return err.credential
+}
+
 func (err CommandStartError) Error() string {
+	var as []string
+	if err.credential != nil {
+		as = append(as, fmt.Sprintf("uid=%d gid=%d", err.credential.Uid, err.credential.Gid))
+		if !err.credential.NoSetGroups {
+			as = append(as, fmt.Sprintf("groups=%v", err.credential.Groups))
+		}
+	}
+	if err.umask != nil {
+		as = append(as, fmt.Sprintf("umask=%04o", uint32(*err.umask)))
+	}
+	if len(as) > 0 {
+		return fmt.Sprintf("error starting command as %s: %s", strings.Join(as, " "), err.Cause())
+	}
 	return fmt.Sprintf("error starting command: %s", err.Cause())
 }
 
@@ -48,3 +76,90 @@ func (err CommandMonitorError) Cause() error {
 func (err CommandMonitorError) Error() string {
 	return fmt.Sprintf("error monitoring command: %s", err.Cause())
 }
+
+/**
+ * Error for a command that was stopped because its Context was done.
+ *
+ * The exit code the command ended with is available, but is a consequence of
+ * how it was stopped (e.g. 137 if it was killed) rather than its own doing.
+ */
+type CommandCancelled struct {
+	cause error
+	code  int
+}
+
+func (err CommandCancelled) Cause() error {
+	return err.cause
+}
+
+func (err CommandCancelled) ExitCode() int {
+	return err.code
+}
+
+func (err CommandCancelled) Error() string {
+	return fmt.Sprintf("command cancelled: %s (exited with status %d)", err.Cause(), err.code)
+}
+
+/**
+ * Error for a command that was stopped because it ran past its timeout.
+ *
+ * As with CommandCancelled, the exit code the command ended with is available,
+ * but is a consequence of how it was stopped.
+ */
+type TimeoutExceeded struct {
+	cmdname string
+	args    []string
+	timeout time.Duration
+	elapsed time.Duration
+	code    int
+}
+
+func (err TimeoutExceeded) Name() string {
+	return err.cmdname
+}
+
+func (err TimeoutExceeded) Args() []string {
+	return err.args
+}
+
+/** Returns how long the command ran for, from start until it was finally stopped. */
+func (err TimeoutExceeded) Elapsed() time.Duration {
+	return err.elapsed
+}
+
+func (err TimeoutExceeded) ExitCode() int {
+	return err.code
+}
+
+func (err TimeoutExceeded) Error() string {
+	return fmt.Sprintf("command \"%s\" with args [%s] exceeded timeout of %s (stopped after %s)", err.cmdname, strings.Join(err.args, " "), err.timeout, err.elapsed)
+}
+
+/**
+ * Error for an attempt to signal a command that isn't running, either because it
+ * hasn't been started yet or because it's already exited.
+ */
+type CommandNotRunning struct {
+	state int32
+}
+
+func (err CommandNotRunning) State() int32 {
+	return err.state
+}
+
+func (err CommandNotRunning) Error() string {
+	if err.state == UNSTARTED {
+		return "command is not running: not yet started"
+	}
+	return "command is not running: already exited"
+}
+
+/**
+ * Error for an attempt to control the terminal of a command that wasn't started
+ * with Opts.Tty.
+ */
+type NotATty struct{}
+
+func (err NotATty) Error() string {
+	return "command is not running on a tty"
+}
@@ -16,6 +16,7 @@ package gosh
 
 import (
 	"bytes"
+	"context"
 	"github.com/coocood/assrt"
 	"os/exec"
 	. "strconv"
@@ -31,6 +32,81 @@ func TestStateConsts(t *testing.T) {
 	}
 }
 
+func TestPshContextCancel(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmdr := NewRunningCommand(
+		exec.Command("sleep", "3"),
+	)
+	cmdr.ctx = &Context{Context: ctx}
+	cmdr.Start()
+	cancel()
+	assert.Equal(
+		true,
+		cmdr.WaitSoon(1*time.Second),
+	)
+	assert.Equal(
+		137,
+		cmdr.GetExitCode(),
+	)
+	assert.Equal(
+		CommandCancelled{cause: context.Canceled, code: 137},
+		cmdr.GetError(),
+	)
+	assert.Equal(
+		CANCELLED,
+		cmdr.State(),
+	)
+}
+
+func TestPshContextCancelGrace(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmdr := NewRunningCommand(
+		// this bash script shrugs off SIGTERM, so it has to be killed once the grace period is up.
+		exec.Command("bash", "-c", "trap '' TERM; sleep 3"),
+	)
+	cmdr.ctx = &Context{Context: ctx, Signal: syscall.SIGTERM, Grace: 100 * time.Millisecond}
+	cmdr.Start()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	assert.Equal(
+		true,
+		cmdr.WaitSoon(1*time.Second),
+	)
+	assert.Equal(
+		137,
+		cmdr.GetExitCode(),
+	)
+	assert.Equal(
+		CANCELLED,
+		cmdr.State(),
+	)
+}
+
+func TestPshContextNotCancelled(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmdr := NewRunningCommand(
+		exec.Command("echo"),
+	)
+	cmdr.ctx = &Context{Context: ctx}
+	cmdr.Start()
+	cmdr.Wait()
+	cancel()
+	assert.Equal(
+		nil,
+		cmdr.GetError(),
+	)
+	assert.Equal(
+		FINISHED,
+		cmdr.State(),
+	)
+}
+
 // Test that we can exec something, wait, and it returns quickly and with an exit code.
 func TestPshExecBasic(t *testing.T) {
 	assert := assrt.NewAssert(t)
@@ -295,3 +371,95 @@ func TestGoshDoesNotReportSigStopOrContinueAsExitEvenUnderPtrace(t *testing.T) {
 		cmdr.State(),
 	)
 }
+
+func TestPshSignal(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("sleep", "3"),
+	)
+	assert.Equal(
+		CommandNotRunning{state: UNSTARTED},
+		cmdr.Signal(syscall.SIGINT),
+	)
+	cmdr.Start()
+	assert.Equal(
+		nil,
+		cmdr.Signal(syscall.SIGINT),
+	)
+	assert.Equal(
+		130,
+		cmdr.GetExitCode(),
+	)
+	assert.Equal(
+		CommandNotRunning{state: FINISHED},
+		cmdr.Signal(syscall.SIGINT),
+	)
+}
+
+func TestPshKill(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("sleep", "3"),
+	)
+	assert.Equal(
+		CommandNotRunning{state: UNSTARTED},
+		cmdr.Kill(),
+	)
+	cmdr.Start()
+	assert.Equal(
+		nil,
+		cmdr.Kill(),
+	)
+	cmdr.Wait()
+	assert.Equal(
+		137,
+		cmdr.GetExitCode(),
+	)
+	assert.Equal(
+		FINISHED,
+		cmdr.State(),
+	)
+	// killing again once it's done is harmless.
+	assert.Equal(
+		nil,
+		cmdr.Kill(),
+	)
+}
+
+func TestPshTerminate(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("sleep", "3"),
+	)
+	cmdr.Start()
+	assert.Equal(
+		nil,
+		cmdr.Terminate(1*time.Second),
+	)
+	assert.Equal(
+		143,
+		cmdr.GetExitCode(),
+	)
+}
+
+func TestPshTerminateEscalatesToKill(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("bash", "-c", "trap '' TERM; sleep 3"),
+	)
+	cmdr.Start()
+	// give the bash time to set up its trap.
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(
+		nil,
+		cmdr.Terminate(100*time.Millisecond),
+	)
+	assert.Equal(
+		137,
+		cmdr.GetExitCode(),
+	)
+}
@@ -0,0 +1,55 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os"
+	"syscall"
+)
+
+/**
+ * The user and groups to run a command as.  Set as Opts.Credential.
+ *
+ * Starting a command with a Credential drops whatever privileges this process has:
+ * the command gets exactly the uid, gid, and supplementary groups listed, and nothing
+ * else.  (Unless KeepGroups is set, in which case it keeps our supplementary groups
+ * and Groups is ignored.)  Switching to another user generally requires running as root.
+ */
+type Credential struct {
+	Uid uint32
+
+	Gid uint32
+
+	/** Supplementary groups.  If nil, the command has none. */
+	Groups []uint32
+
+	KeepGroups bool
+}
+
+func (cred *Credential) sys() *syscall.Credential {
+	return &syscall.Credential{
+		Uid:         cred.Uid,
+		Gid:         cred.Gid,
+		Groups:      cred.Groups,
+		NoSetGroups: cred.KeepGroups,
+	}
+}
+
+/**
+ * Returns a pointer to the mode, for filling in Opts.Umask.
+ */
+func Umask(mask os.FileMode) *os.FileMode {
+	return &mask
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+	"github.com/coocood/assrt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestCredential(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("switching users requires running as root")
+	}
+	assert := assrt.NewAssert(t)
+
+	out := Sh("sh")("-c", "id -u; id -g; id -G")(Opts{Credential: &Credential{
+		Uid:    65534,
+		Gid:    65534,
+		Groups: []uint32{100},
+	}}).Output()
+	assert.Equal(
+		"65534\n65534\n65534 100\n",
+		out,
+	)
+}
+
+func TestUmask(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	before := syscall.Umask(0022)
+	syscall.Umask(before)
+
+	out := Sh("sh")("-c", "umask")(Opts{Umask: Umask(0077)}).Output()
+	assert.Equal(
+		"0077\n",
+		out,
+	)
+
+	after := syscall.Umask(0022)
+	syscall.Umask(after)
+	assert.Equal(
+		before,
+		after,
+	)
+}
+
+func TestUmaskArgv0(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	expect := "bash"
+	if !probeTrampolineShell().execArgv0 {
+		expect, _ = exec.LookPath("bash")
+	}
+	out := Sh("bash")("-c", "echo $0")(Opts{Umask: Umask(0077)}).Output()
+	assert.Equal(
+		expect+"\n",
+		out,
+	)
+}
+
+func TestUmaskDoesNotLeak(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	before := syscall.Umask(0022)
+	syscall.Umask(before)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Sh("true")(Opts{Umask: Umask(0077)})()
+		}
+	}()
+	leaked := 0
+	for i := 0; i < 100; i++ {
+		if Sh("sh")("-c", "umask").Output() != fmt.Sprintf("%04o\n", before) {
+			leaked++
+		}
+	}
+	<-done
+	assert.Equal(
+		0,
+		leaked,
+	)
+}
+
+func TestCredentialInStartError(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("/thishadbetternotbeacommand")(Opts{
+		Credential: &Credential{Uid: 1000, Gid: 1000, Groups: []uint32{27}},
+		Umask:      Umask(0027),
+	}).RunE().(CommandStartError)
+	assert.Equal(
+		uint32(1000),
+		err.Credential().Uid,
+	)
+	assert.Equal(
+		"error starting command as uid=1000 gid=1000 groups=[27] umask=0027: fork/exec /thishadbetternotbeacommand: no such file or directory",
+		err.Error(),
+	)
+}
@@ -0,0 +1,227 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"polydawn.net/pogo/iox"
+	"sync"
+)
+
+/** How much of the output to quote in a DecodeFailure. */
+const decodeExcerptLimit = 256
+
+/**
+ * Runs the command, and decodes its output as a JSON document into v, as by json.Unmarshal.
+ *
+ * If the output can't be decoded, returns a DecodeFailure, which includes the command's
+ * exit status and an excerpt of the output around where decoding failed.  Otherwise
+ * returns the same as RunE().
+ */
+func (f Command) OutputJSON(v interface{}) error {
+	var buf bytes.Buffer
+	p, err := f.BakeOpts(Opts{Out: &buf}).StartPipelineE()
+	if err != nil {
+		return err
+	}
+	p.Wait()
+	failure := p.failure()
+	if _, exited := failure.(FailureExitCode); failure != nil && !exited {
+		return failure
+	}
+	if err := json.Unmarshal(buf.Bytes(), v); err != nil {
+		offset := int64(len(buf.Bytes()))
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			offset = e.Offset
+		case *json.UnmarshalTypeError:
+			offset = e.Offset
+		}
+		return DecodeFailure{
+			Format:          "json",
+			Excerpt:         excerptAround(buf.Bytes(), int(offset)),
+			Cause:           err,
+			FailureExitCode: p.stageFailure(len(p.stages) - 1),
+		}
+	}
+	return failure
+}
+
+func excerptAround(output []byte, offset int) string {
+	start, end := offset-decodeExcerptLimit/2, offset+decodeExcerptLimit/2
+	if start < 0 {
+		start = 0
+	}
+	if end > len(output) {
+		end = len(output)
+	}
+	if start > end {
+		start = end
+	}
+	return string(output[start:end])
+}
+
+/**
+ * A sink for Opts.Out (or Err) that decodes records from the output as it arrives,
+ * and hands each to a func.  Make one with JSONLines(), CSVRows(), or TSVRows().
+ *
+ * If a record can't be decoded, or the func returns an error, no more records are
+ * decoded, the command's further writes fail, and Run() reports a DecodeFailure
+ * (even if the command then exits successfully).  Each RecordWriter keeps track of
+ * how far it's got, so use a new one for each run.
+ */
+type RecordWriter struct {
+	format string
+	w      io.Writer
+	finish func()
+
+	mutex   sync.Mutex
+	records int
+	excerpt string
+	err     error
+}
+
+func (r *RecordWriter) Write(p []byte) (int, error) {
+	return r.w.Write(p)
+}
+
+/** Returns how many records have been decoded and handled so far. */
+func (r *RecordWriter) Records() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.records
+}
+
+/** Returns the first error decoding or handling a record, if any. */
+func (r *RecordWriter) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+/**
+ * Decodes whatever's left, once the command has exited.
+ */
+func (r *RecordWriter) Close() error {
+	r.finish()
+	return r.Err()
+}
+
+/**
+ * Counts a record, or records the first failure.  Returns the failure, if there's been one.
+ */
+func (r *RecordWriter) handled(err error, excerpt func() string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if err != nil {
+		r.err = err
+		r.excerpt = excerpt()
+		return err
+	}
+	r.records++
+	return nil
+}
+
+/**
+ * Decodes each line of output as a JSON value, and calls each with it.  Blank lines
+ * are skipped.  Unmarshal each line into whatever type is appropriate, e.g.
+ *
+ *   var events []Event
+ *   Opts{Out: JSONLines(func(line json.RawMessage) error {
+ *       var e Event
+ *       err := json.Unmarshal(line, &e)
+ *       events = append(events, e)
+ *       return err
+ *   })}
+ */
+func JSONLines(each func(line json.RawMessage) error) *RecordWriter {
+	r := &RecordWriter{format: "json lines"}
+	lines := iox.NewLineWriter(func(line string) error {
+		raw := bytes.TrimSpace([]byte(line))
+		if len(raw) == 0 {
+			return r.Err()
+		}
+		var err error
+		if !json.Valid(raw) {
+			var v interface{}
+			err = json.Unmarshal(raw, &v)
+		} else {
+			err = each(json.RawMessage(raw))
+		}
+		return r.handled(err, func() string {
+			return excerptAround(raw, decodeExcerptLimit/2)
+		})
+	})
+	r.w = lines
+	r.finish = func() {
+		lines.Flush()
+	}
+	return r
+}
+
+/**
+ * Decodes the output as CSV, as by encoding/csv with its defaults, and calls each
+ * with each row.
+ */
+func CSVRows(each func(row []string) error) *RecordWriter {
+	return csvRows("csv", ',', each)
+}
+
+/**
+ * Decodes the output as tab-separated values, and calls each with each row.
+ * Quoting follows the same rules as CSV.
+ */
+func TSVRows(each func(row []string) error) *RecordWriter {
+	return csvRows("tsv", '\t', each)
+}
+
+func csvRows(format string, comma rune, each func(row []string) error) *RecordWriter {
+	r := &RecordWriter{format: format}
+	pr, pw := io.Pipe()
+	tail := newTailWriter(decodeExcerptLimit)
+	r.w = io.MultiWriter(tail, pw)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		reader := csv.NewReader(pr)
+		reader.Comma = comma
+		reader.FieldsPerRecord = -1
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err == nil {
+				err = each(row)
+			}
+			if err = r.handled(err, tail.String); err != nil {
+				// the command's writes fail from now on.
+				pr.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	r.finish = func() {
+		pw.Close()
+		<-done
+	}
+	return r
+}
@@ -0,0 +1,167 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coocood/assrt"
+	"strings"
+	"testing"
+)
+
+func TestOutputJSON(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var v struct {
+		A int
+		B []string
+	}
+	err := Sh("echo")(`{"a": 1, "b": ["x", "y"]}`).OutputJSON(&v)
+	assert.Nil(err)
+	assert.Equal(1, v.A)
+	assert.Equal(
+		[]string{"x", "y"},
+		v.B,
+	)
+}
+
+func TestOutputJSONDecodeFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var v map[string]int
+	err, ok := Sh("echo")(`{"a": 1,, "b": 2}`).OutputJSON(&v).(DecodeFailure)
+	assert.True(ok)
+	assert.Equal(
+		"json",
+		err.Format,
+	)
+	assert.Equal(
+		0,
+		err.Code,
+	)
+	assert.True(strings.Contains(err.Excerpt, `"a": 1,,`))
+	assert.True(strings.Contains(err.Error(), "exit status: 0"))
+
+	// output that doesn't fit the type is a failure too.
+	_, ok = Sh("echo")(`{"a": "one"}`).OutputJSON(&v).(DecodeFailure)
+	assert.True(ok)
+}
+
+func TestOutputJSONExitFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// decodable output; the exit status is the problem.
+	var v map[string]int
+	_, ok := Sh("sh")("-c", `echo '{"a": 1}'; exit 3`).OutputJSON(&v).(FailureExitCode)
+	assert.True(ok)
+	assert.Equal(1, v["a"])
+
+	// undecodable output, and the status goes along with the decoding problem.
+	err, ok := Sh("sh")("-c", `echo oops; exit 3`).OutputJSON(&v).(DecodeFailure)
+	assert.True(ok)
+	assert.Equal(3, err.Code)
+}
+
+func TestJSONLines(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var names []string
+	sink := JSONLines(func(line json.RawMessage) error {
+		var v struct{ Name string }
+		err := json.Unmarshal(line, &v)
+		names = append(names, v.Name)
+		return err
+	})
+	err := Sh("printf")(`{"name": "a"}\n\n {"name": "b"} \n{"name": "c"}`)(Opts{Out: sink}).RunE()
+	assert.Nil(err)
+	assert.Equal(
+		[]string{"a", "b", "c"},
+		names,
+	)
+	assert.Equal(3, sink.Records())
+}
+
+func TestJSONLinesDecodeFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	sink := JSONLines(func(line json.RawMessage) error { return nil })
+	err, ok := Sh("printf")(`{}\nnot json\n{}\n`)(Opts{Out: sink}).RunE().(DecodeFailure)
+	assert.True(ok)
+	assert.Equal(
+		"json lines",
+		err.Format,
+	)
+	assert.Equal(2, err.Record)
+	assert.Equal(
+		"not json",
+		err.Excerpt,
+	)
+}
+
+func TestCSVRows(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var rows [][]string
+	sink := CSVRows(func(row []string) error {
+		rows = append(rows, row)
+		return nil
+	})
+	Sh("printf")(`a,b\n"with, comma","with\nnewline"\nshort\n`)(Opts{Out: sink}).Run()
+	assert.Equal(
+		[][]string{{"a", "b"}, {"with, comma", "with\nnewline"}, {"short"}},
+		rows,
+	)
+
+	rows = nil
+	sink = TSVRows(func(row []string) error {
+		rows = append(rows, row)
+		return nil
+	})
+	Sh("printf")(`a\tb,c\n1\t2\n`)(Opts{Out: sink}).Run()
+	assert.Equal(
+		[][]string{{"a", "b,c"}, {"1", "2"}},
+		rows,
+	)
+}
+
+func TestCSVRowsDecodeFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	sink := CSVRows(func(row []string) error { return nil })
+	err, ok := Sh("printf")(`a,b\nc,d"e\n`)(Opts{Out: sink}).RunE().(DecodeFailure)
+	assert.True(ok)
+	assert.Equal(2, err.Record)
+	assert.True(strings.Contains(err.Excerpt, `c,d"e`))
+}
+
+func TestRecordWriterStopsTheCommand(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the command has far more to say than fits in a pipe; it mustn't be left blocked.
+	sink := CSVRows(func(row []string) error {
+		if row[0] == "3" {
+			return fmt.Errorf("three is right out")
+		}
+		return nil
+	})
+	err, ok := Sh("seq")("1", "1000000")(Opts{Out: sink}).RunE().(DecodeFailure)
+	assert.True(ok)
+	assert.Equal(3, err.Record)
+	assert.Equal(
+		"three is right out",
+		err.Cause.Error(),
+	)
+}
@@ -0,0 +1,150 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+/**
+ * An environment, as "KEY=value" entries, each key at most once, in a stable order:
+ * inherited variables in the order the OS gave them to us, and each newly set variable
+ * after them in the order it was set.  Setting a variable that's already there keeps
+ * its place.
+ *
+ * It's never modified in place, since commandTemplates copied from one another share it.
+ */
+type environment []string
+
+/**
+ * Returns the environment of this process.  If a key appears more than once, the
+ * last value wins, which is what exec does with duplicates too.
+ */
+func osEnvironment() environment {
+	var env environment
+	for _, line := range os.Environ() {
+		chunks := strings.SplitN(line, "=", 2)
+		if len(chunks) != 2 {
+			continue
+		}
+		env = env.with(chunks[0], chunks[1])
+	}
+	return env
+}
+
+func (env environment) index(key string) int {
+	for i, entry := range env {
+		if len(entry) > len(key) && entry[len(key)] == '=' && entry[:len(key)] == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (env environment) lookup(key string) (string, bool) {
+	i := env.index(key)
+	if i < 0 {
+		return "", false
+	}
+	return env[i][len(key)+1:], true
+}
+
+func (env environment) with(key string, value string) environment {
+	i := env.index(key)
+	if i < 0 {
+		// capped so that append always copies.
+		return append(env[:len(env):len(env)], key+"="+value)
+	}
+	changed := make(environment, len(env))
+	copy(changed, env)
+	changed[i] = key + "=" + value
+	return changed
+}
+
+func (env environment) without(key string) environment {
+	i := env.index(key)
+	if i < 0 {
+		return env
+	}
+	changed := make(environment, 0, len(env)-1)
+	changed = append(changed, env[:i]...)
+	return append(changed, env[i+1:]...)
+}
+
+func (env environment) only(keys []string) environment {
+	kept := environment{}
+	for _, entry := range env {
+		for _, key := range keys {
+			if strings.HasPrefix(entry, key+"=") {
+				kept = append(kept, entry)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+/**
+ * Returns the values with variable references expanded against this environment,
+ * as an Env ready to apply.
+ */
+func (env environment) expand(values EnvExpand) Env {
+	expanded := make(Env, len(values))
+	for k, v := range values {
+		expanded[k] = os.Expand(v, func(key string) string {
+			value, _ := env.lookup(key)
+			return value
+		})
+	}
+	return expanded
+}
+
+/**
+ * Adds dirs to the front or the back of PATH.  If PATH is unset or empty, it becomes just dirs.
+ */
+func (env environment) withPath(dirs string, prepend bool) environment {
+	path, _ := env.lookup("PATH")
+	switch {
+	case path == "":
+		path = dirs
+	case prepend:
+		path = dirs + ":" + path
+	default:
+		path = path + ":" + dirs
+	}
+	return env.with("PATH", path)
+}
+
+/**
+ * Applies the changes in an Env, in order of key so the result doesn't depend on
+ * map iteration.
+ */
+func (env environment) apply(changes Env) environment {
+	keys := make([]string, 0, len(changes))
+	for k := range changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := changes[k]; v == Unset {
+			env = env.without(k)
+		} else {
+			env = env.with(k, v)
+		}
+	}
+	return env
+}
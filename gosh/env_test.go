@@ -0,0 +1,125 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"os"
+	"testing"
+)
+
+func TestEnvOrderIsDeterministic(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("env")(ClearEnv{})(Env{"C": "3", "A": "1", "B": "2"})
+	for i := 0; i < 10; i++ {
+		assert.Equal(
+			"A=1\nB=2\nC=3\n",
+			cmd.Output(),
+		)
+	}
+}
+
+func TestEnvKeepsPlaceWhenReset(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("env")(ClearEnv{})(Env{"Z": "1"})(Env{"A": "2"})(Env{"Z": "3"})
+	assert.Equal(
+		[]string{"Z=3", "A=2"},
+		cmd.Environ(),
+	)
+}
+
+func TestEnvEmptyIsNotUnset(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("sh")("-c", `echo "[${EMPTY-unset}] [${GONE-unset}]"`)(Env{"GONE": "here"})(Env{
+		"EMPTY": "",
+		"GONE":  Unset,
+	})
+	assert.Equal(
+		"[] [unset]\n",
+		cmd.Output(),
+	)
+
+	value, ok := cmd.LookupEnv("EMPTY")
+	assert.Equal("", value)
+	assert.True(ok)
+	_, ok = cmd.LookupEnv("GONE")
+	assert.False(ok)
+}
+
+func TestEnvInherited(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	os.Setenv("GOSH_TEST_INHERITED", "yes")
+	defer os.Unsetenv("GOSH_TEST_INHERITED")
+	value, ok := Sh("env").LookupEnv("GOSH_TEST_INHERITED")
+	assert.Equal("yes", value)
+	assert.True(ok)
+}
+
+func TestEnvNotSharedBetweenCommands(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	base := Sh("env")(ClearEnv{})(Env{"A": "1"})
+	base(Env{"A": "2", "B": "3"})
+	base(Env{"A": Unset})
+	assert.Equal(
+		[]string{"A=1"},
+		base.Environ(),
+	)
+}
+
+func TestEnvPathHelpers(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("sh")("-c", "echo $PATH")(ClearEnv{})(Env{"PATH": "/usr/bin:/bin"})
+	assert.Equal(
+		"/opt/a:/usr/bin:/bin:/opt/z\n",
+		cmd(PrependPath("/opt/a"), AppendPath("/opt/z")).Output(),
+	)
+
+	value, _ := Sh("sh")(ClearEnv{})(AppendPath("/opt/z")).LookupEnv("PATH")
+	assert.Equal(
+		"/opt/z",
+		value,
+	)
+}
+
+func TestEnvExpand(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("env")(ClearEnv{})(Env{"HOME": "/home/gosh", "X": "1"})(EnvExpand{
+		"GOPATH": "$HOME/go",
+		"X":      "${X}2",
+		"HOME":   "/elsewhere",
+		"NOPE":   "[$NOPE]",
+	})
+	assert.Equal(
+		[]string{"HOME=/elsewhere", "X=12", "GOPATH=/home/gosh/go", "NOPE=[]"},
+		cmd.Environ(),
+	)
+}
+
+func TestEnvInheritOnly(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("env")(ClearEnv{})(Env{"A": "1", "B": "2", "C": "3"})(InheritOnly("C", "A", "D"))
+	assert.Equal(
+		"A=1\nC=3\n",
+		cmd.Output(),
+	)
+}
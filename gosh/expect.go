@@ -0,0 +1,265 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"bytes"
+	"io"
+	"polydawn.net/pogo/iox"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/**
+ * Drives an interactive command, expect-style: wait for the output to match
+ * a pattern, send some input, and repeat.
+ *
+ * Output is accumulated as it arrives, regardless of how the command chunks its
+ * writes, and each Expect() consumes the output up to the end of what it matched.
+ * Everything read and sent is also recorded in a transcript.
+ */
+type Session struct {
+	cmd *RunningCommand
+
+	in  chan string
+	out chan string
+
+	/** Guards sending on and closing in. */
+	inMutex  sync.Mutex
+	inClosed bool
+
+	mutex sync.Mutex
+
+	/** Output that has arrived but not yet been consumed by a match. */
+	buf []byte
+
+	/** Everything read from and sent to the command, in order. */
+	transcript bytes.Buffer
+
+	/** Set once all output from the command has arrived. */
+	eof bool
+
+	/** Gets a value whenever buf grows or eof is set. */
+	notify chan bool
+}
+
+/**
+ * Starts the command as an interactive Session.  This acts as BakeOpts() with
+ * In and Out set; if Err has not been configured, stderr is read along with stdout.
+ *
+ * As with Start(), a panic of type CommandStartError will be emitted if the command
+ * cannot be started; use StartSessionE() to get it as an error instead.
+ */
+func (f Command) StartSession() *Session {
+	s, err := f.StartSessionE()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+/**
+ * Same as StartSession(), but returns a CommandStartError instead of panicking if
+ * the command cannot be started.
+ */
+func (f Command) StartSessionE() (*Session, error) {
+	s := &Session{
+		in:     make(chan string),
+		out:    make(chan string),
+		notify: make(chan bool, 1),
+	}
+	// output is sent on as it's written, not a line at a time; prompts don't end in line breaks.
+	out := iox.WriterToChanString(s.out)
+	opts := Opts{In: s.in, Out: out}
+	if f.expose().Err == nil {
+		opts.Err = out
+	}
+	cmd, err := f.BakeOpts(opts).StartE()
+	if err != nil {
+		return nil, err
+	}
+	s.cmd = cmd
+	go s.read()
+	go func() {
+		// the command can't finish while its input is still open, even if the process is gone.
+		<-cmd.reapCh
+		s.Close()
+	}()
+	return s, nil
+}
+
+func (s *Session) read() {
+	for {
+		done := false
+		select {
+		case chunk := <-s.out:
+			s.mutex.Lock()
+			s.buf = append(s.buf, chunk...)
+			s.transcript.WriteString(chunk)
+			s.mutex.Unlock()
+		case <-s.cmd.GetExitChannel():
+			// once the command is done, every write to out has already been received.
+			s.mutex.Lock()
+			s.eof = true
+			s.mutex.Unlock()
+			done = true
+		}
+		select {
+		case s.notify <- true:
+		default:
+		}
+		if done {
+			return
+		}
+	}
+}
+
+/** Returns the RunningCommand the session is driving. */
+func (s *Session) Command() *RunningCommand {
+	return s.cmd
+}
+
+/**
+ * Sends input to the command.  Returns a CommandNotRunning error if the command has
+ * already exited, or io.ErrClosedPipe if the session's input has been closed.
+ */
+func (s *Session) Send(str string) error {
+	s.inMutex.Lock()
+	defer s.inMutex.Unlock()
+
+	select {
+	case <-s.cmd.reapCh:
+		return CommandNotRunning{state: FINISHED}
+	default:
+	}
+	if s.inClosed {
+		return io.ErrClosedPipe
+	}
+	select {
+	case s.in <- str:
+		s.mutex.Lock()
+		s.transcript.WriteString(str)
+		s.mutex.Unlock()
+		return nil
+	case <-s.cmd.reapCh:
+		return CommandNotRunning{state: FINISHED}
+	}
+}
+
+/**
+ * Sends input to the command, followed by a line break.
+ */
+func (s *Session) SendLine(str string) error {
+	return s.Send(str + "\n")
+}
+
+/**
+ * Closes the command's input.  Closing it again has no effect.
+ */
+func (s *Session) Close() {
+	s.inMutex.Lock()
+	defer s.inMutex.Unlock()
+
+	if !s.inClosed {
+		close(s.in)
+		s.inClosed = true
+	}
+}
+
+/**
+ * Waits until the command's output matches the pattern, then consumes the output
+ * up to the end of the match and returns the match and its capture groups, as
+ * regexp.FindStringSubmatch() would.
+ *
+ * Returns an ExpectTimeout error if there is no match within the timeout, or an
+ * ExpectEOF error if the command exits and all its output has arrived without a
+ * match.  In either case, no output is consumed.  A timeout of zero waits forever.
+ */
+func (s *Session) Expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	for {
+		s.mutex.Lock()
+		if loc := re.FindSubmatchIndex(s.buf); loc != nil {
+			match := make([]string, len(loc)/2)
+			for i := range match {
+				if loc[2*i] >= 0 {
+					match[i] = string(s.buf[loc[2*i]:loc[2*i+1]])
+				}
+			}
+			s.buf = s.buf[loc[1]:]
+			s.mutex.Unlock()
+			return match, nil
+		}
+		eof, pending := s.eof, string(s.buf)
+		s.mutex.Unlock()
+
+		if eof {
+			return nil, ExpectEOF{pattern: re.String(), pending: pending}
+		}
+		select {
+		case <-s.notify:
+		case <-deadline:
+			return nil, ExpectTimeout{pattern: re.String(), timeout: timeout, pending: pending}
+		}
+	}
+}
+
+/**
+ * Waits for the command to exit, and returns whatever output had not yet been
+ * consumed.  Returns an ExpectTimeout error if the command is still running after
+ * the timeout.  A timeout of zero waits forever.
+ */
+func (s *Session) ExpectEOF(timeout time.Duration) (string, error) {
+	exited := true
+	if timeout > 0 {
+		exited = s.cmd.WaitSoon(timeout)
+	} else {
+		s.cmd.Wait()
+	}
+	if exited {
+		// the reader may not have caught up with the exit yet.
+		for {
+			s.mutex.Lock()
+			eof := s.eof
+			s.mutex.Unlock()
+			if eof {
+				break
+			}
+			<-s.notify
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending := string(s.buf)
+	if !exited {
+		return "", ExpectTimeout{pattern: "EOF", timeout: timeout, pending: pending}
+	}
+	s.buf = nil
+	return pending, nil
+}
+
+/**
+ * Returns everything read from and sent to the command so far, in order.
+ */
+func (s *Session) Transcript() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.transcript.String()
+}
@@ -0,0 +1,55 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+	"time"
+)
+
+/**
+ * Error when Session.Expect() gives up waiting for a match.
+ */
+type ExpectTimeout struct {
+	pattern string
+	timeout time.Duration
+	pending string
+}
+
+/** Returns the output that had arrived but not been matched when the wait gave up. */
+func (err ExpectTimeout) Pending() string {
+	return err.pending
+}
+
+func (err ExpectTimeout) Error() string {
+	return fmt.Sprintf("expect: timed out after %s waiting for %q; unmatched output: %q", err.timeout, err.pattern, err.pending)
+}
+
+/**
+ * Error when Session.Expect() can't match because the command has exited.
+ */
+type ExpectEOF struct {
+	pattern string
+	pending string
+}
+
+/** Returns the output that had arrived but not been matched when the command exited. */
+func (err ExpectEOF) Pending() string {
+	return err.pending
+}
+
+func (err ExpectEOF) Error() string {
+	return fmt.Sprintf("expect: command exited before output matched %q; unmatched output: %q", err.pattern, err.pending)
+}
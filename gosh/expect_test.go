@@ -0,0 +1,109 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExpectAcrossChunks(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the prompt arrives in pieces, and not on a line of its own.
+	s := Sh("bash")("-c", "printf 'user'; sleep 0.1; printf 'name: '; read name; echo \"hello, $name!\"").StartSession()
+
+	_, err := s.Expect(regexp.MustCompile(`username: $`), time.Second)
+	assert.Equal(
+		nil,
+		err,
+	)
+	assert.Equal(
+		nil,
+		s.SendLine("bees"),
+	)
+	match, err := s.Expect(regexp.MustCompile(`hello, (\w+)!`), time.Second)
+	assert.Equal(
+		nil,
+		err,
+	)
+	assert.Equal(
+		[]string{"hello, bees!", "bees"},
+		match,
+	)
+	rest, err := s.ExpectEOF(time.Second)
+	assert.Equal(
+		"\n",
+		rest,
+	)
+	assert.Equal(
+		0,
+		s.Command().GetExitCode(),
+	)
+	assert.Equal(
+		"username: bees\nhello, bees!\n",
+		s.Transcript(),
+	)
+}
+
+func TestExpectTimeout(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	s := Sh("bash")("-c", "echo nope; sleep 1").StartSession()
+	_, err := s.Expect(regexp.MustCompile(`yep`), 200*time.Millisecond)
+	assert.Equal(
+		ExpectTimeout{pattern: "yep", timeout: 200 * time.Millisecond, pending: "nope\n"},
+		err,
+	)
+	s.Command().Kill()
+}
+
+func TestExpectEOF(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	s := Sh("echo")("nope").StartSession()
+	_, err := s.Expect(regexp.MustCompile(`yep`), time.Second)
+	assert.Equal(
+		ExpectEOF{pattern: "yep", pending: "nope\n"},
+		err,
+	)
+	assert.Equal(
+		CommandNotRunning{state: FINISHED},
+		s.Send("too late"),
+	)
+}
+
+func TestExpectOnTty(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	s := Sh("bash")("-c", "read -s -p 'Password: ' pw; echo; [ -t 0 ] && echo \"got ${#pw} chars\"")(Opts{Tty: &Tty{}}).StartSession()
+	_, err := s.Expect(regexp.MustCompile(`Password: `), time.Second)
+	assert.Equal(
+		nil,
+		err,
+	)
+	s.SendLine("hunter2")
+	match, err := s.Expect(regexp.MustCompile(`got (\d+) chars`), time.Second)
+	assert.Equal(
+		nil,
+		err,
+	)
+	assert.Equal(
+		"7",
+		match[1],
+	)
+}
@@ -16,9 +16,18 @@ package gosh
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"polydawn.net/pogo/iox"
+	"strings"
+	"syscall"
 	"testing"
+	"text/template"
+	"time"
 )
 
 var Printf = fmt.Printf
@@ -53,6 +62,22 @@ func TestIntegration_ShOutputWithStringChan(t *testing.T) {
 	)
 }
 
+func TestIntegration_ShOutputWithStringChanIsLines(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	out := make(chan string, 10)
+	Sh("sh")("-c", "printf 'a\\nb'; sleep 0.1; printf 'c\\nd'; sleep 0.1; printf 'e' >&2")(Opts{Out: out, Err: out})()
+	close(out)
+	var lines []string
+	for line := range out {
+		lines = append(lines, line)
+	}
+	assert.Equal(
+		[]string{"a\n", "bc\n", "de"},
+		lines,
+	)
+}
+
 func TestIntegration_ShOutputWithByteSliceChan(t *testing.T) {
 	assert := assrt.NewAssert(t)
 
@@ -129,6 +154,50 @@ func TestIntegration_ShInputWithBuffer(t *testing.T) {
 	)
 }
 
+func TestIntegration_ShInputWithTemplate(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	tmpl := template.Must(template.New("").Parse("{{range .}}host {{.}}\n{{end}}"))
+	out := Sh("cat")(Opts{In: iox.TemplateInput{Template: tmpl, Data: []string{"a", "b"}}}).Output()
+	assert.Equal(
+		"host a\nhost b\n",
+		out,
+	)
+}
+
+func TestIntegration_ShInputWithTemplateNotAllRead(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// far more than fits in a pipe; the rendering has to be stopped when head exits.
+	tmpl := template.Must(template.New("").Parse("{{range .}}{{.}}\n{{end}}"))
+	out := Sh("head")("-n", "2")(Opts{In: iox.TemplateInput{Template: tmpl, Data: make([]int, 1000000)}}).Output()
+	assert.Equal(
+		"0\n0\n",
+		out,
+	)
+}
+
+func TestIntegration_ShInputWithMultiInput(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ch := make(chan string, 2)
+	ch <- "from "
+	ch <- "a chan\n"
+	close(ch)
+	tmpl := template.Must(template.New("").Parse("from a {{.}}\n"))
+	out := Sh("cat")(Opts{In: iox.MultiInput{
+		"from a string\n",
+		[]byte("from bytes\n"),
+		ch,
+		strings.NewReader("from a reader\n"),
+		iox.TemplateInput{Template: tmpl, Data: "template"},
+	}}).Output()
+	assert.Equal(
+		"from a string\nfrom bytes\nfrom a chan\nfrom a reader\nfrom a template\n",
+		out,
+	)
+}
+
 func TestIntegration_ShStreamingInputAndOutputWithStringChan(t *testing.T) {
 	assert := assrt.NewAssert(t)
 
@@ -193,3 +262,462 @@ func TestIntegration_NotATty(t *testing.T) {
 		p.GetExitCode(),
 	)
 }
+
+func TestIntegration_ShPipeline(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	echo := Sh("echo")("wat")
+	upper := Sh("tr")("a-z", "A-Z")(Opts{In: echo})
+
+	assert.Equal(
+		"WAT\n",
+		upper.Output(),
+	)
+}
+
+func TestIntegration_ShPipelineMultiStage(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cat := Sh("cat")("-")(Opts{In: "zebra\napple\nmango\n"})
+	sort := Sh("sort")(Opts{In: cat})
+	head := Sh("head")("-n", "2")(Opts{In: sort})
+
+	assert.Equal(
+		"apple\nmango\n",
+		head.Output(),
+	)
+}
+
+func TestIntegration_ShPipelineWaitsForAllStages(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	slow := Sh("bash")("-c", "exec 1>&-; sleep 0.2; exit 3")
+	p := Sh("cat")(Opts{In: slow}).Start()
+
+	assert.Equal(
+		0,
+		p.GetExitCode(),
+	)
+	assert.Equal(
+		true,
+		p.upstream.IsDone(),
+	)
+	assert.Equal(
+		3,
+		p.upstream.GetExitCode(),
+	)
+}
+
+func TestIntegration_ShRunE(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	assert.Equal(
+		nil,
+		Sh("true").RunE(),
+	)
+	assert.Equal(
+		14,
+		Sh("bash")("-c")("exit 14").RunE().(FailureExitCode).Code,
+	)
+}
+
+func TestIntegration_ShRunENonexistentCommand(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("/thishadbetternotbeacommand").RunE()
+	_, ok := err.(CommandStartError)
+	assert.Equal(
+		true,
+		ok,
+	)
+}
+
+func TestIntegration_ShOutputE(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	out, err := Sh("sh")("-c", "echo out ; echo err 1>&2 ; exit 2").OutputE()
+	assert.Equal(
+		"out\n",
+		out,
+	)
+	assert.Equal(
+		2,
+		err.(FailureExitCode).Code,
+	)
+
+	out, err = Sh("sh")("-c", "echo out ; echo err 1>&2 ;").CombinedOutputE()
+	assert.Equal(
+		"out\nerr\n",
+		out,
+	)
+	assert.Equal(
+		nil,
+		err,
+	)
+}
+
+func TestIntegration_ShContextTimeout(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := Sh("sleep")("3")(Context{Context: ctx}).RunE()
+	assert.Equal(
+		CommandCancelled{cause: context.DeadlineExceeded, code: 137},
+		err,
+	)
+}
+
+func TestIntegration_ShProcessGroupWaitsForWholeTree(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the shell exits right away, but leaves a grandchild behind in its process group.
+	start := time.Now()
+	p := Sh("bash")("-c", "sleep 0.5 >/dev/null & exit 0")(Opts{ProcessGroup: NEW_PROCESS_GROUP}).Start()
+	assert.Equal(
+		0,
+		p.GetExitCode(),
+	)
+	assert.Equal(
+		true,
+		time.Since(start) >= 500*time.Millisecond,
+	)
+}
+
+func TestIntegration_ShProcessGroupWaitsForLongLivedTree(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// long enough that the group is checked through /proc, and polled less often.
+	start := time.Now()
+	p := Sh("bash")("-c", "sleep 1.5 >/dev/null & exit 0")(Opts{ProcessGroup: NEW_PROCESS_GROUP}).Start()
+	p.Wait()
+	assert.True(time.Since(start) >= 1500*time.Millisecond)
+	assert.True(time.Since(start) < 3*time.Second)
+}
+
+func TestIntegration_ShProcessGroupCanBeReset(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	grouped := Sh("bash")("-c", "ps -o pgid= -p $$; ps -o pgid= -p $PPID")(Opts{ProcessGroup: NEW_PROCESS_GROUP})
+	pgids := strings.Fields(grouped.Output())
+	assert.NotEqual(
+		pgids[0],
+		pgids[1],
+	)
+	pgids = strings.Fields(grouped(Opts{ProcessGroup: INHERIT_GROUP}).Output())
+	assert.Equal(
+		pgids[0],
+		pgids[1],
+	)
+}
+
+func TestIntegration_ShProcessGroupKillsWholeTree(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	p := Sh("bash")("-c", "sleep 5 >/dev/null & wait")(Opts{ProcessGroup: NEW_SESSION}).Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(
+		nil,
+		p.Kill(),
+	)
+	assert.Equal(
+		true,
+		p.WaitSoon(1*time.Second),
+	)
+	assert.Equal(
+		137,
+		p.GetExitCode(),
+	)
+}
+
+func TestIntegration_ShTimeout(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("sleep")("3")(Opts{Timeout: 100 * time.Millisecond}).RunE()
+	timeout, ok := err.(TimeoutExceeded)
+	assert.Equal(
+		true,
+		ok,
+	)
+	assert.Equal(
+		"sleep",
+		timeout.Name(),
+	)
+	assert.Equal(
+		[]string{"3"},
+		timeout.Args(),
+	)
+	assert.Equal(
+		137,
+		timeout.ExitCode(),
+	)
+	assert.Equal(
+		true,
+		timeout.Elapsed() >= 100*time.Millisecond && timeout.Elapsed() < 3*time.Second,
+	)
+}
+
+func TestIntegration_ShTimeoutGrace(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("sleep")("3")(Opts{Timeout: 100 * time.Millisecond, KillGrace: time.Second}).RunE()
+	assert.Equal(
+		143,
+		err.(TimeoutExceeded).ExitCode(),
+	)
+}
+
+func TestIntegration_ShTimeoutNotExceeded(t *testing.T) {
+	Sh("true")(Opts{Timeout: 1 * time.Second})()
+}
+
+func TestIntegration_ShTty(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	out, err := Sh("bash")("-c", "test -t 0 && test -t 1 && test -t 2 && echo tty")(Opts{Tty: &Tty{}}).OutputE()
+	assert.Equal(
+		nil,
+		err,
+	)
+	assert.Equal(
+		"tty\r\n",
+		out,
+	)
+}
+
+func TestIntegration_ShTtySize(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	out := Sh("stty")("size")(Opts{Tty: &Tty{Rows: 24, Cols: 100}}).Output()
+	assert.Equal(
+		"24 100\r\n",
+		out,
+	)
+}
+
+func TestIntegration_ShTtyResize(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	in := make(chan string)
+	var out bytes.Buffer
+	p := Sh("bash")("-c", "stty -echo; echo ready; read; stty size")(Opts{In: in, Out: &out, Tty: &Tty{Rows: 24, Cols: 80}}).Start()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(
+		nil,
+		p.Resize(40, 120),
+	)
+	in <- "\n"
+	close(in)
+	assert.Equal(
+		0,
+		p.GetExitCode(),
+	)
+	assert.Equal(
+		"ready\r\n40 120\r\n",
+		out.String(),
+	)
+}
+
+func TestIntegration_ShTtyInput(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the terminal echoes the input, and then cat repeats it.
+	out := Sh("cat")(Opts{In: "bees\n", Tty: &Tty{}}).Output()
+	assert.Equal(
+		"bees\r\nbees\r\n",
+		out,
+	)
+}
+
+func TestIntegration_ShFailureExitCodeDetails(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var errOut bytes.Buffer
+	err := Sh("bash")("-c", "echo oh no 1>&2; exit 3")(Opts{Cwd: "/", Err: &errOut}).RunE().(FailureExitCode)
+	assert.Equal(
+		"bash",
+		err.Cmdname,
+	)
+	assert.Equal(
+		[]string{"-c", "echo oh no 1>&2; exit 3"},
+		err.Args,
+	)
+	assert.Equal(
+		"/",
+		err.Cwd,
+	)
+	assert.Equal(
+		3,
+		err.Code,
+	)
+	assert.Equal(
+		syscall.Signal(0),
+		err.Signal,
+	)
+	assert.Equal(
+		true,
+		err.Duration > 0,
+	)
+	// stderr is kept for the error, and still goes where it was asked to.
+	assert.Equal(
+		"oh no\n",
+		err.StderrTail,
+	)
+	assert.Equal(
+		"oh no\n",
+		errOut.String(),
+	)
+}
+
+func TestIntegration_ShFailureExitCodeStderrTailIsBounded(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("bash")("-c", "head -c 10000 /dev/zero | tr '\\0' a 1>&2; echo -n end 1>&2; exit 1").RunE().(FailureExitCode)
+	assert.Equal(
+		stderrTailLimit,
+		len(err.StderrTail),
+	)
+	assert.Equal(
+		"aaaend",
+		err.StderrTail[len(err.StderrTail)-6:],
+	)
+}
+
+func TestIntegration_ShFailureExitCodeStderrTailWithoutErr(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("bash")("-c", "echo oh no 1>&2; exit 3").RunE().(FailureExitCode)
+	assert.Equal(
+		"oh no\n",
+		err.StderrTail,
+	)
+}
+
+func TestIntegration_ShDoesNotWaitForBackgroundedStderr(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	start := time.Now()
+	Sh("bash")("-c", "sleep 3 &")()
+	assert.True(time.Since(start) < time.Second)
+
+	// stderr is copied for the tail, but the copying stops soon after the command exits.
+	var errOut bytes.Buffer
+	start = time.Now()
+	err := Sh("bash")("-c", "echo oh no 1>&2; sleep 3 & exit 1")(Opts{Err: &errOut}).RunE().(FailureExitCode)
+	assert.True(time.Since(start) < time.Second)
+	assert.Equal(
+		"oh no\n",
+		err.StderrTail,
+	)
+	assert.Equal(
+		"oh no\n",
+		errOut.String(),
+	)
+
+	// and a timeout isn't held up either.
+	start = time.Now()
+	_, ok := Sh("bash")("-c", "sleep 3 & sleep 10")(Opts{Timeout: 200 * time.Millisecond, Err: &errOut}).RunE().(TimeoutExceeded)
+	assert.True(ok)
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestIntegration_ShBackgroundedStderrOutlivesCommand(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	dir, err := ioutil.TempDir("", "gosh-test-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	script := "(for i in 1 2 3; do sleep .1; echo tick >&2; done; touch $0) &"
+
+	// a file is handed straight to the command, so it's written to for as long as anyone holds it.
+	f, err := os.Create(filepath.Join(dir, "log"))
+	assert.Nil(err)
+	Sh("bash")("-c", script, "file-done")(Opts{Cwd: dir, Err: f})()
+	f.Close()
+	// and when stderr is being read by us, it's still read after we stop waiting on it.
+	Sh("bash")("-c", script, "tee-done")(Opts{Cwd: dir})()
+
+	time.Sleep(time.Second)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "log"))
+	assert.Nil(err)
+	assert.Equal(
+		"tick\ntick\ntick\n",
+		string(content),
+	)
+	for _, marker := range []string{"file-done", "tee-done"} {
+		_, err = os.Stat(filepath.Join(dir, marker))
+		assert.Nil(err)
+	}
+}
+
+func TestIntegration_ShFailureExitCodeSignal(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("bash")("-c", "kill -9 $$").RunE().(FailureExitCode)
+	assert.Equal(
+		137,
+		err.Code,
+	)
+	assert.Equal(
+		syscall.SIGKILL,
+		err.Signal,
+	)
+}
+
+func TestIntegration_ShOutputCaptureLimit(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("seq")("1", "100000")(Opts{Capture: &iox.CaptureOpts{Limit: 12, Mode: iox.KEEP_HEAD_AND_TAIL}})
+	assert.Equal(
+		"1\n2\n3\n\n[... 588883 bytes dropped ...]\n00000\n",
+		cmd.Output(),
+	)
+
+	capture, err := Sh("seq")("1", "100000")(Opts{Capture: &iox.CaptureOpts{Limit: 7, Mode: iox.KEEP_TAIL}}).OutputCapture()
+	assert.Nil(err)
+	assert.Equal(
+		"100000\n",
+		capture.String(),
+	)
+	assert.Equal(
+		int64(588895),
+		capture.Total(),
+	)
+}
+
+func TestIntegration_ShCombinedOutputCaptureLimit(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	out := Sh("sh")("-c", "echo out; echo err >&2")(Opts{Capture: &iox.CaptureOpts{Limit: 4}}).CombinedOutput()
+	assert.Equal(
+		"out\n",
+		out,
+	)
+}
+
+func TestIntegration_ShOutputCaptureSpillFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the command still gets to write all its output, and succeeds; the capture in memory is unaffected.
+	capture, err := Sh("seq")("1", "100000")(Opts{Capture: &iox.CaptureOpts{Limit: 7, Mode: iox.KEEP_TAIL, Spill: true, SpillDir: "/nonexistent"}}).OutputCapture()
+	assert.Equal(
+		"100000\n",
+		capture.String(),
+	)
+	assert.Nil(err)
+	assert.NotNil(capture.Err())
+
+	// nor does it matter to anything that doesn't keep the spill.
+	out, err := Sh("seq")("1", "100000")(Opts{Capture: &iox.CaptureOpts{Limit: 7, Mode: iox.KEEP_TAIL, Spill: true, SpillDir: "/nonexistent"}}).OutputE()
+	assert.Nil(err)
+	assert.Equal(
+		"100000\n",
+		out,
+	)
+	out, err = Sh("seq")("1", "100000")(Opts{Capture: &iox.CaptureOpts{Limit: 7, Mode: iox.KEEP_TAIL, Spill: true, SpillDir: "/nonexistent"}}).CombinedOutputE()
+	assert.Nil(err)
+	assert.Equal(
+		"100000\n",
+		out,
+	)
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os"
+	"syscall"
+)
+
+/**
+ * Linux namespaces and a root directory to run a command in.  Set as Opts.Isolation.
+ *
+ * Each namespace flag gives the command a fresh namespace of that kind, shared with
+ * nothing but its own children.  This is enough to run a hermetic step without a
+ * container runtime, but it's no more than the kernel's primitives: a new mount
+ * namespace starts as a copy of ours, a new network namespace has only a loopback
+ * interface (and that one down), and so on.
+ *
+ * In a new pid namespace, the command is pid 1.  When it exits, everything else in the
+ * namespace is killed along with it.  Signals that it doesn't handle are ignored if they
+ * come from inside the namespace, but signals from us (Signal, Kill, Terminate, timeouts)
+ * are delivered as usual, and exit codes follow the same 128+signal convention as ever.
+ */
+type Isolation struct {
+	PidNamespace bool
+
+	/**
+	 * Run the command in a new mount namespace.  Every mount in it is made private
+	 * (recursively, from / down) before the command starts, so that nothing the command
+	 * mounts or unmounts propagates back out to us, even where our mounts are shared
+	 * (as they are by default wherever systemd is in charge).  Mounts of ours don't
+	 * propagate in, either.
+	 */
+	MountNamespace bool
+
+	NetNamespace bool
+
+	UtsNamespace bool
+
+	/**
+	 * Run the command in a new user namespace.  This is the one that doesn't require
+	 * privileges to create, and the other namespaces may then be created along with it.
+	 * If UidMappings and GidMappings are empty, our own uid and gid are mapped to root
+	 * inside the namespace.
+	 */
+	UserNamespace bool
+
+	UidMappings []IdMapping
+
+	GidMappings []IdMapping
+
+	/**
+	 * If provided, the command is chrooted into this directory.  Opts.Cwd is then relative
+	 * to the new root, but note that the command's path is still looked up out here.
+	 * So are the paths of any FileRedirects, which are opened by us before the command
+	 * starts; they must be absolute, since there's no sensible directory for them to be
+	 * relative to.
+	 *
+	 * There's no pivot_root: it has to be called between fork and exec, and the go runtime
+	 * gives us no way to do that.  If you want the old root unreachable, combine Root with
+	 * MountNamespace (and expect chroot's usual escapes to apply to privileged commands).
+	 */
+	Root string
+}
+
+/**
+ * Maps a range of ids in a user namespace to ids outside it.
+ */
+type IdMapping struct {
+	/** First id inside the namespace. */
+	ContainerId int
+
+	/** First id outside the namespace. */
+	HostId int
+
+	Size int
+}
+
+func (iso *Isolation) applyTo(attr *syscall.SysProcAttr) {
+	if iso.PidNamespace {
+		attr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+	if iso.MountNamespace {
+		// unsharing rather than cloning gets / remounted private, which cloning doesn't.
+		attr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+	if iso.NetNamespace {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if iso.UtsNamespace {
+		attr.Cloneflags |= syscall.CLONE_NEWUTS
+	}
+	if iso.UserNamespace {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = idMappings(iso.UidMappings, os.Getuid())
+		attr.GidMappings = idMappings(iso.GidMappings, os.Getgid())
+	}
+	attr.Chroot = iso.Root
+}
+
+func idMappings(mappings []IdMapping, self int) []syscall.SysProcIDMap {
+	if len(mappings) == 0 {
+		return []syscall.SysProcIDMap{{ContainerID: 0, HostID: self, Size: 1}}
+	}
+	sysMappings := make([]syscall.SysProcIDMap, len(mappings))
+	for i, m := range mappings {
+		sysMappings[i] = syscall.SysProcIDMap{ContainerID: m.ContainerId, HostID: m.HostId, Size: m.Size}
+	}
+	return sysMappings
+}
@@ -0,0 +1,162 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func requireRoot(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating namespaces requires running as root")
+	}
+}
+
+func TestIsolationPidNamespace(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	out := Sh("sh")("-c", "echo $$")(Opts{Isolation: &Isolation{PidNamespace: true}}).Output()
+	assert.Equal(
+		"1\n",
+		out,
+	)
+}
+
+func TestIsolationPidNamespaceKilledExitCode(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("sleep")("10")(Opts{Isolation: &Isolation{PidNamespace: true}}).Start()
+	assert.Nil(cmd.Kill())
+	assert.Equal(
+		137,
+		cmd.GetExitCode(),
+	)
+}
+
+func TestIsolationNetAndUtsNamespaces(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	out := Sh("sh")("-c", "hostname sandbox; hostname; tail -n +3 /proc/net/dev | cut -d: -f1")(Opts{Isolation: &Isolation{
+		NetNamespace: true,
+		UtsNamespace: true,
+	}}).Output()
+	assert.Equal(
+		"sandbox\n    lo\n",
+		out,
+	)
+
+	hostname, _ := os.Hostname()
+	assert.NotEqual(
+		"sandbox",
+		hostname,
+	)
+}
+
+func TestIsolationUserNamespace(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	out := Sh("sh")("-c", "cat /proc/self/uid_map")(Opts{Isolation: &Isolation{
+		UserNamespace: true,
+		UidMappings:   []IdMapping{{ContainerId: 1000, HostId: 0, Size: 1}},
+	}}).Output()
+	assert.Equal(
+		[]string{"1000", "0", "1"},
+		strings.Fields(out),
+	)
+}
+
+func TestIsolationRoot(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	// build a root with just a shell and what it needs to load.
+	root, err := ioutil.TempDir("", "gosh-isolation-")
+	assert.Nil(err)
+	defer os.RemoveAll(root)
+	files := []string{"/bin/sh"}
+	for _, line := range strings.Split(Sh("ldd")("/bin/sh").Output(), "\n") {
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "/") {
+				files = append(files, field)
+			}
+		}
+	}
+	for _, file := range files {
+		real, err := filepath.EvalSymlinks(file)
+		assert.Nil(err)
+		content, err := ioutil.ReadFile(real)
+		assert.Nil(err)
+		assert.Nil(os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755))
+		assert.Nil(ioutil.WriteFile(filepath.Join(root, file), content, 0755))
+	}
+	assert.Nil(os.Mkdir(filepath.Join(root, "work"), 0755))
+
+	Sh("/bin/sh")("-c", "echo $PWD > marker")(Opts{
+		Cwd:       "/work",
+		Isolation: &Isolation{Root: root},
+	}).Run()
+	content, err := ioutil.ReadFile(filepath.Join(root, "work", "marker"))
+	assert.Nil(err)
+	assert.Equal(
+		"/work\n",
+		string(content),
+	)
+}
+
+func TestIsolationMountNamespaceIsPrivate(t *testing.T) {
+	requireRoot(t)
+	assert := assrt.NewAssert(t)
+
+	// a shared mount of ours, as systemd would have made it.
+	dir, err := ioutil.TempDir("", "gosh-isolation-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(syscall.Mount("none", dir, "tmpfs", 0, ""))
+	defer syscall.Unmount(dir, syscall.MNT_DETACH)
+	assert.Nil(syscall.Mount("", dir, "", syscall.MS_SHARED, ""))
+	assert.Nil(os.Mkdir(filepath.Join(dir, "sub"), 0755))
+
+	Sh("sh")("-c", "mount -t tmpfs none sub && touch sub/marker")(Opts{
+		Cwd:       dir,
+		Isolation: &Isolation{MountNamespace: true},
+	}).Run()
+	_, err = os.Stat(filepath.Join(dir, "sub", "marker"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestIsolationRootRejectsRelativeRedirect(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("sh")(Opts{
+		Out:       ToFile("out", 0644),
+		Isolation: &Isolation{Root: "/nonexistent"},
+	}).RunE()
+	_, ok := err.(CommandStartError)
+	assert.True(ok)
+	assert.Equal(
+		"error starting command: redirect to relative path \"out\" can't be used with Isolation.Root",
+		err.Error(),
+	)
+}
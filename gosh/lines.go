@@ -0,0 +1,155 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"bufio"
+	"os"
+	"syscall"
+)
+
+/**
+ * Reads a command's output a line at a time, in the manner of bufio.Scanner:
+ *
+ *   lines := Sh("git")("log", "--oneline").Lines()
+ *   for lines.Scan() {
+ *       fmt.Println(lines.Text())
+ *   }
+ *   if err := lines.Err(); err != nil {
+ *       ...
+ *   }
+ *
+ * The command writes straight into a pipe that's only read as fast as Scan() is
+ * called, so a command that produces output faster than it's consumed simply blocks
+ * until it's caught up with.  When Scan() returns false, Err() reports whatever went
+ * wrong: an error starting the command, an error reading its output, or otherwise
+ * whatever RunE() would have.
+ *
+ * Lines can be up to a megabyte long by default; see Buffer() to change that.  A longer
+ * line is an error: scanning stops with bufio.ErrTooLong, and the command is left to
+ * die of SIGPIPE at its next write, as with Close().
+ */
+type LineScanner struct {
+	pipeline *RunningPipeline
+	pipe     *os.File
+	scanner  *bufio.Scanner
+	err      error
+	done     bool
+}
+
+/**
+ * Starts the command with its output going to a LineScanner.  Any Out that's been
+ * set is replaced.  If the command can't be started, the first Scan() returns false
+ * and Err() returns the CommandStartError.
+ */
+func (f Command) Lines() *LineScanner {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return &LineScanner{err: CommandStartError{cause: err}, done: true}
+	}
+	p, err := f.BakeOpts(Opts{Out: w}).StartPipelineE()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return &LineScanner{err: err, done: true}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	return &LineScanner{
+		pipeline: p,
+		pipe:     r,
+		scanner:  scanner,
+	}
+}
+
+/** The longest line a LineScanner reads, unless told otherwise with Buffer(). */
+const maxLineLength = 1024 * 1024
+
+/**
+ * Sets the buffer to read lines into, and the longest line that can be read (which
+ * may be more than the buffer's capacity; it's grown as needed), as for
+ * bufio.Scanner.Buffer().  Must be called before the first Scan().
+ */
+func (s *LineScanner) Buffer(buf []byte, max int) {
+	if s.scanner != nil {
+		s.scanner.Buffer(buf, max)
+	}
+}
+
+/**
+ * Advances to the next line, which is then available from Text().  Returns false
+ * when there are no more lines, or there was an error; see Err().
+ */
+func (s *LineScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	if s.scanner.Scan() {
+		return true
+	}
+	s.finish(s.scanner.Err(), false)
+	return false
+}
+
+/** Returns the current line, without its line break. */
+func (s *LineScanner) Text() string {
+	if s.scanner == nil {
+		return ""
+	}
+	return s.scanner.Text()
+}
+
+/**
+ * Returns why scanning stopped, once Scan() has returned false: nil if the command
+ * ran to completion and succeeded.
+ */
+func (s *LineScanner) Err() error {
+	return s.err
+}
+
+/** Returns the running command (the last stage, if it's a pipeline), or nil if it couldn't be started. */
+func (s *LineScanner) Command() *RunningCommand {
+	if s.pipeline == nil {
+		return nil
+	}
+	return s.pipeline.Last()
+}
+
+/**
+ * Stops reading before the output is done, as `| head` would: the command's next
+ * write fails, and it gets SIGPIPE.  Waits for the command to exit, and returns
+ * the same as Err(), except that dying of SIGPIPE isn't counted as a failure.
+ */
+func (s *LineScanner) Close() error {
+	s.finish(nil, true)
+	return s.err
+}
+
+func (s *LineScanner) finish(readErr error, closing bool) {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.pipe.Close()
+	s.pipeline.Wait()
+	if readErr != nil {
+		s.err = readErr
+		return
+	}
+	s.err = s.pipeline.failure()
+	if failure, ok := s.err.(FailureExitCode); ok && closing && failure.Signal == syscall.SIGPIPE {
+		s.err = nil
+	}
+}
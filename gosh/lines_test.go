@@ -0,0 +1,135 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"bufio"
+	"github.com/coocood/assrt"
+	"testing"
+	"time"
+)
+
+func TestLines(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	lines := Sh("printf")("a\\nb b\\n\\nc").Lines()
+	var got []string
+	for lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	assert.Nil(lines.Err())
+	assert.Equal(
+		[]string{"a", "b b", "", "c"},
+		got,
+	)
+}
+
+func TestLinesReportsFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	lines := Sh("sh")("-c", "echo one; echo two; exit 3").Lines()
+	count := 0
+	for lines.Scan() {
+		count++
+	}
+	assert.Equal(2, count)
+	err, ok := lines.Err().(FailureExitCode)
+	assert.True(ok)
+	assert.Equal(
+		3,
+		err.Code,
+	)
+}
+
+func TestLinesStartError(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	lines := Sh("/thishadbetternotbeacommand").Lines()
+	assert.False(lines.Scan())
+	_, ok := lines.Err().(CommandStartError)
+	assert.True(ok)
+	assert.Nil(lines.Command())
+}
+
+func TestLinesBackpressure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// far more than a pipe holds: the command can't finish until we've read it.
+	lines := Sh("seq")("1", "1000000").Lines()
+	assert.True(lines.Scan())
+	time.Sleep(100 * time.Millisecond)
+	assert.False(lines.Command().IsDone())
+
+	count := 1
+	for lines.Scan() {
+		count++
+	}
+	assert.Nil(lines.Err())
+	assert.Equal(1000000, count)
+}
+
+func TestLinesCloseEarly(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	lines := Sh("seq")("1", "1000000").Lines()
+	assert.True(lines.Scan())
+	assert.Equal("1", lines.Text())
+	assert.Nil(lines.Close())
+	assert.True(lines.Command().IsDone())
+	assert.False(lines.Scan())
+}
+
+func TestLinesPipeline(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	lines := Sh("sort")("-r")(Opts{In: Sh("printf")("a\\nc\\nb\\n")}).Lines()
+	var got []string
+	for lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	assert.Nil(lines.Err())
+	assert.Equal(
+		[]string{"c", "b", "a"},
+		got,
+	)
+}
+
+func TestLinesLongLine(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// longer than bufio.Scanner would take by default.
+	lines := Sh("bash")("-c", "head -c 100000 /dev/zero | tr '\\0' a; echo; echo end").Lines()
+	assert.True(lines.Scan())
+	assert.Equal(
+		100000,
+		len(lines.Text()),
+	)
+	assert.True(lines.Scan())
+	assert.Equal(
+		"end",
+		lines.Text(),
+	)
+	assert.False(lines.Scan())
+	assert.Nil(lines.Err())
+
+	// and a line past the limit is an error.
+	lines = Sh("bash")("-c", "head -c 100000 /dev/zero | tr '\\0' a").Lines()
+	lines.Buffer(nil, 1000)
+	assert.False(lines.Scan())
+	assert.Equal(
+		bufio.ErrTooLong,
+		lines.Err(),
+	)
+}
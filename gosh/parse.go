@@ -0,0 +1,261 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+ * Parses a simple shell command line into a Command, without running a shell, e.g.
+ *   `FOO=1 make -C dir "all tests" > build.log 2>&1`
+ *
+ * Words are split and quoted as in POSIX sh: single quotes keep everything literally,
+ * double quotes keep everything but backslash escapes of $ ` " and \, and a backslash
+ * outside quotes escapes any character.  Leading VAR=value words become an Env.
+ * Redirections become Opts: `< file` is In, `> file` and `>> file` are Out, `2> file`
+ * and `2>> file` are Err, and `2>&1` sends Err wherever Out goes.  Files are opened
+ * when the command is started, relative to its Cwd.
+ *
+ * Globs and ~ aren't expanded; they're kept literally.  Anything else that would need
+ * a real shell -- variable or command substitution, pipes, lists, subshells -- is a
+ * ParseError rather than being passed along as if it were text.
+ */
+func Parse(line string) (Command, error) {
+	p := &parser{line: line}
+	words, err := p.words()
+	if err != nil {
+		return nil, err
+	}
+
+	env := Env{}
+	var opts Opts
+	var argv []string
+	outRedirected := false
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if w.op != "" {
+			if w.op == "2>&1" {
+				if opts.Out != nil {
+					opts.Err = opts.Out
+				} else {
+					opts.Err = stderrToStdout{}
+				}
+				continue
+			}
+			if i+1 >= len(words) || words[i+1].op != "" {
+				return nil, ParseError{line: line, offset: w.offset, msg: "missing file name after " + w.op}
+			}
+			i++
+			path := words[i].text
+			switch w.op {
+			case "<":
+				opts.In = FromFile(path)
+			case ">":
+				opts.Out = ToFile(path, 0666)
+				outRedirected = true
+			case ">>":
+				opts.Out = AppendFile(path)
+				outRedirected = true
+			case "2>":
+				opts.Err = ToFile(path, 0666)
+			case "2>>":
+				opts.Err = AppendFile(path)
+			}
+			if opts.Err == (stderrToStdout{}) && outRedirected {
+				// `2>&1 > file` sends stderr to wherever stdout was *before*, which we don't know.
+				return nil, ParseError{line: line, offset: w.offset, msg: "2>&1 before redirecting stdout is not supported"}
+			}
+			continue
+		}
+		if len(argv) == 0 && w.assignment > 0 {
+			env[w.text[:w.assignment]] = w.text[w.assignment+1:]
+			continue
+		}
+		argv = append(argv, w.text)
+	}
+	if len(argv) == 0 {
+		return nil, ParseError{line: line, offset: len(line), msg: "no command"}
+	}
+
+	cmd := Sh(argv[0])
+	if len(argv) > 1 {
+		cmd = cmd.BakeArgs(argv[1:]...)
+	}
+	if len(env) > 0 {
+		cmd = cmd.BakeEnv(env)
+	}
+	if opts.In != nil || opts.Out != nil || opts.Err != nil {
+		cmd = cmd.BakeOpts(opts)
+	}
+	return cmd, nil
+}
+
+type parser struct {
+	line string
+	pos  int
+}
+
+/**
+ * A word of the command line, or a redirection operator.
+ */
+type parsedWord struct {
+	text   string
+	op     string
+	offset int
+
+	/** If the word is a VAR=value assignment, the index of the '='; otherwise zero. */
+	assignment int
+}
+
+func (p *parser) words() ([]parsedWord, error) {
+	var words []parsedWord
+	for {
+		// skip blanks, and line continuations between words.
+		for p.pos < len(p.line) {
+			if strings.HasPrefix(p.line[p.pos:], "\\\n") {
+				p.pos += 2
+			} else if strings.IndexByte(" \t\n", p.line[p.pos]) >= 0 {
+				p.pos++
+			} else {
+				break
+			}
+		}
+		if p.pos >= len(p.line) || p.line[p.pos] == '#' {
+			return words, nil
+		}
+		if op := p.operator(); op != "" {
+			words = append(words, parsedWord{op: op, offset: p.pos})
+			p.pos += len(op)
+			continue
+		}
+		w, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+}
+
+/**
+ * Returns the redirection operator at the current position, if there is one.
+ */
+func (p *parser) operator() string {
+	for _, op := range []string{"2>&1", "2>>", "2>", ">>", ">", "<"} {
+		if strings.HasPrefix(p.line[p.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+/**
+ * Reads one word, up to an unquoted blank or operator.
+ */
+func (p *parser) word() (parsedWord, error) {
+	w := parsedWord{offset: p.pos}
+	var text strings.Builder
+	quoted := false
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+		switch {
+		case strings.IndexByte(" \t\n<>", c) >= 0:
+			w.text = text.String()
+			return w, nil
+		case strings.IndexByte("|&;()`", c) >= 0:
+			return w, p.errorf(p.pos, "%q is not supported", string(c))
+		case c == '$' && p.pos+1 < len(p.line) && isExpansionStart(p.line[p.pos+1]):
+			return w, p.errorf(p.pos, "expansion of %q is not supported", p.line[p.pos:p.pos+2])
+		case c == '\\':
+			if p.pos+1 >= len(p.line) {
+				return w, p.errorf(p.pos, "trailing backslash")
+			}
+			if p.line[p.pos+1] != '\n' {
+				text.WriteByte(p.line[p.pos+1])
+			}
+			quoted = true
+			p.pos += 2
+		case c == '\'':
+			end := strings.IndexByte(p.line[p.pos+1:], '\'')
+			if end < 0 {
+				return w, p.errorf(p.pos, "unterminated single quote")
+			}
+			text.WriteString(p.line[p.pos+1 : p.pos+1+end])
+			quoted = true
+			p.pos += end + 2
+		case c == '"':
+			if err := p.doubleQuoted(&text); err != nil {
+				return w, err
+			}
+			quoted = true
+		case c == '=' && !quoted && w.assignment == 0 && isName(text.String()):
+			w.assignment = text.Len()
+			text.WriteByte(c)
+			p.pos++
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	w.text = text.String()
+	return w, nil
+}
+
+func (p *parser) doubleQuoted(text *strings.Builder) error {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.line) {
+		c := p.line[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return nil
+		case c == '`':
+			return p.errorf(p.pos, "\"`\" is not supported")
+		case c == '$' && p.pos+1 < len(p.line) && isExpansionStart(p.line[p.pos+1]):
+			return p.errorf(p.pos, "expansion of %q is not supported", p.line[p.pos:p.pos+2])
+		case c == '\\' && p.pos+1 < len(p.line) && strings.IndexByte("$`\"\\\n", p.line[p.pos+1]) >= 0:
+			if p.line[p.pos+1] != '\n' {
+				text.WriteByte(p.line[p.pos+1])
+			}
+			p.pos += 2
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	return p.errorf(start, "unterminated double quote")
+}
+
+func (p *parser) errorf(offset int, format string, args ...interface{}) error {
+	return ParseError{line: p.line, offset: offset, msg: fmt.Sprintf(format, args...)}
+}
+
+func isExpansionStart(c byte) bool {
+	return c == '{' || c == '(' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("@*#?$!-", c) >= 0
+}
+
+func isName(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+)
+
+/**
+ * Error when Parse() can't make a Command of a line.
+ */
+type ParseError struct {
+	line   string
+	offset int
+	msg    string
+}
+
+/** Returns the byte offset in the line where the problem was found. */
+func (err ParseError) Offset() int {
+	return err.offset
+}
+
+func (err ParseError) Error() string {
+	return fmt.Sprintf("sh: cannot parse %q: %s at offset %d", err.line, err.msg, err.offset)
+}
@@ -0,0 +1,185 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseWords(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd, err := Parse(`FOO=1 make -C dir "all tests"`)
+	assert.Nil(err)
+	assert.Equal(
+		"make",
+		cmd.Name(),
+	)
+	assert.Equal(
+		[]string{"-C", "dir", "all tests"},
+		cmd.Args(),
+	)
+	value, _ := cmd.LookupEnv("FOO")
+	assert.Equal(
+		"1",
+		value,
+	)
+}
+
+func TestParseQuoting(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	for line, args := range map[string][]string{
+		`echo 'single $HOME "quoted"'`:    {`single $HOME "quoted"`},
+		`echo "double \$ \" \\ \n 'x'"`:   {`double $ " \ \n 'x'`},
+		`echo back\ slash \'x\' \"`:       {"back slash", "'x'", `"`},
+		`echo a"b"'c'd ""`:                {"abcd", ""},
+		"echo one \\\n  two # a comment":  {"one", "two"},
+		`echo X=1 "Y"=2 *.go ~ $`:         {"X=1", "Y=2", "*.go", "~", "$"},
+		"echo\ttabs\n\tand\nnewlines":     {"tabs", "and", "newlines"},
+		`echo "multi word" arg 'arg two'`: {"multi word", "arg", "arg two"},
+	} {
+		cmd, err := Parse(line)
+		assert.Nil(err, line)
+		assert.Equal(
+			args,
+			cmd.Args(),
+			line,
+		)
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd, err := Parse(`A= B='x y' env C=3`)
+	assert.Nil(err)
+	assert.Equal(
+		"env",
+		cmd.Name(),
+	)
+	assert.Equal(
+		[]string{"C=3"},
+		cmd.Args(),
+	)
+	value, ok := cmd.LookupEnv("A")
+	assert.Equal("", value)
+	assert.True(ok)
+	value, _ = cmd.LookupEnv("B")
+	assert.Equal("x y", value)
+	_, ok = cmd.LookupEnv("C")
+	assert.False(ok)
+}
+
+func TestParseRedirections(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	dir, err := ioutil.TempDir("", "gosh-parse-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "in"), []byte("from a file\n"), 0644))
+
+	cmd, err := Parse(`sh -c 'cat; echo err >&2' <in >out 2>&1`)
+	assert.Nil(err)
+	cmd(Opts{Cwd: dir}).Run()
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.Equal(
+		"from a file\nerr\n",
+		string(content),
+	)
+
+	cmd, err = Parse(`sh -c 'echo again; echo err2 >&2' >> out 2> err`)
+	assert.Nil(err)
+	cmd(Opts{Cwd: dir}).Run()
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.Equal(
+		"from a file\nerr\nagain\n",
+		string(content),
+	)
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "err"))
+	assert.Equal(
+		"err2\n",
+		string(content),
+	)
+
+	// 2>&1 with stdout left to whoever runs it.
+	cmd, err = Parse(`sh -c 'echo out; echo err >&2' 2>&1`)
+	assert.Nil(err)
+	assert.Equal(
+		"out\nerr\n",
+		cmd.Output(),
+	)
+}
+
+func TestParseRedirectionMissingFile(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd, err := Parse(`cat < /thishadbetternotexist`)
+	assert.Nil(err)
+	_, ok := cmd.RunE().(CommandStartError)
+	assert.True(ok)
+}
+
+func TestParseErrors(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	for line, offset := range map[string]int{
+		``:                0,
+		`FOO=1`:           5,
+		`echo 'open`:      5,
+		`echo "open`:      5,
+		`echo trailing\`:  13,
+		`echo $HOME`:      5,
+		`echo "${HOME}"`:  6,
+		"echo `date`":     5,
+		`echo a | cat`:    7,
+		`echo a; echo b`:  6,
+		`sleep 1 &`:       8,
+		`cat <`:           4,
+		`cat > < x`:       4,
+		`cat 2>&1 > file`: 9,
+	} {
+		_, err := Parse(line)
+		parseErr, ok := err.(ParseError)
+		assert.True(ok, line)
+		assert.Equal(
+			offset,
+			parseErr.Offset(),
+			line,
+		)
+	}
+}
+
+func TestParseRendersBack(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	for _, line := range []string{
+		`make -C dir 'all tests'`,
+		`GOSH_X=1 cat < in > out 2>&1`,
+		`cat >> out 2> err`,
+		`cat 2>&1`,
+	} {
+		cmd, err := Parse(line)
+		assert.Nil(err)
+		assert.Equal(
+			line,
+			cmd.String(),
+		)
+	}
+}
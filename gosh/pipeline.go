@@ -0,0 +1,366 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/**
+ * Policy for deciding whether a pipeline as a whole was successful.
+ * Configured by Opts.Pipefail on the last command of the pipeline.
+ *
+ * The zero value isn't a policy; it's what leaves Opts.Pipefail unset, so that
+ * LAST_STAGE can be set explicitly to override a policy baked in earlier.
+ */
+type PipefailPolicy int
+
+const (
+	/**
+	 * Only the last stage of the pipeline is checked against its OkExit codes.
+	 * This is how a shell behaves by default.
+	 */
+	LAST_STAGE PipefailPolicy = iota + 1
+
+	/**
+	 * The pipeline fails if any stage fails, and the rightmost failing stage is reported.
+	 * This is how a shell behaves with `set -o pipefail`.
+	 */
+	PIPEFAIL
+
+	/**
+	 * The pipeline fails if any stage fails, and every failing stage is reported.
+	 */
+	ALL_STAGES
+)
+
+/**
+ * A set of RunningCommands connected by pipes, as started by Command.StartPipeline().
+ *
+ * A command that doesn't have another Command as its input is a pipeline with only one stage.
+ */
+type RunningPipeline struct {
+	stages []*RunningCommand
+
+	/** The templates each stage was started from, in the same order as stages. */
+	cmdts []*commandTemplate
+
+	policy PipefailPolicy
+}
+
+/** Returns every stage of the pipeline, from the first to the last. */
+func (p *RunningPipeline) Stages() []*RunningCommand {
+	return p.stages
+}
+
+/** Returns the last stage of the pipeline, i.e. the one whose output is the pipeline's output. */
+func (p *RunningPipeline) Last() *RunningCommand {
+	return p.stages[len(p.stages)-1]
+}
+
+func (p *RunningPipeline) Policy() PipefailPolicy {
+	return p.policy
+}
+
+/**
+ * Waits for every stage of the pipeline to exit before returning.
+ */
+func (p *RunningPipeline) Wait() {
+	for _, stage := range p.stages {
+		stage.Wait()
+	}
+}
+
+/**
+ * Waits for every stage of the pipeline to exit before returning, or for the specified duration.
+ * Returns true if the return was due to the pipeline finishing, or false if the
+ * return was due to timeout.
+ */
+func (p *RunningPipeline) WaitSoon(d time.Duration) bool {
+	timeout := time.After(d)
+	for _, stage := range p.stages {
+		select {
+		case <-timeout:
+			return false
+		case <-stage.GetExitChannel():
+		}
+	}
+	return true
+}
+
+/**
+ * Waits for the pipeline to exit if it has not already, then returns the exit codes
+ * of every stage, from the first to the last.
+ */
+func (p *RunningPipeline) GetExitCodes() []int {
+	codes := make([]int, len(p.stages))
+	for i, stage := range p.stages {
+		codes[i] = stage.GetExitCode()
+	}
+	return codes
+}
+
+/**
+ * Waits for the pipeline to exit if it has not already, then returns the exit code
+ * of the pipeline as a whole.
+ *
+ * Under the LAST_STAGE policy, this is the exit code of the last stage.  Under the
+ * other policies, this is the exit code of the rightmost stage that failed, or of the
+ * last stage if none failed.
+ */
+func (p *RunningPipeline) GetExitCode() int {
+	if p.policy != LAST_STAGE {
+		if failed := p.failedStages(); len(failed) > 0 {
+			return p.stages[failed[len(failed)-1]].GetExitCode()
+		}
+	}
+	return p.Last().GetExitCode()
+}
+
+/**
+ * Returns the indexes of the stages that count as failed under the pipeline's policy.
+ * Waits for the pipeline to exit if it has not already.
+ */
+func (p *RunningPipeline) failedStages() []int {
+	var failed []int
+	for i, stage := range p.stages {
+		if p.policy == LAST_STAGE && i != len(p.stages)-1 {
+			continue
+		}
+		if !isOkExit(stage.GetExitCode(), p.cmdts[i].OkExit) {
+			failed = append(failed, i)
+		}
+	}
+	if p.policy == PIPEFAIL && len(failed) > 1 {
+		failed = failed[len(failed)-1:]
+	}
+	return failed
+}
+
+/**
+ * Waits for the pipeline to exit if it has not already, then returns an error
+ * describing the failure if it was unsuccessful, or nil.
+ *
+ * The first of these that applies is returned:
+ *   - a stage's own error, if it has one, e.g. a CommandMonitorError or TimeoutExceeded;
+ *   - a DecodeFailure, if any stage's output couldn't be decoded by a RecordWriter;
+ *   - a ResourceLimitExceeded, if a failed stage was stopped for exceeding its Rlimits;
+ *   - an OutOfMemory, if a failed stage ran out of memory in its Cgroup;
+ *   - a FailureExitCode, if the pipeline has only one stage and it failed;
+ *   - a PipelineFailure, if a longer pipeline failed.
+ * Which stages count as failed depends on the pipefail policy (and on their OkExit codes).
+ * The first two apply to any stage, whatever the policy.
+ */
+func (p *RunningPipeline) failure() error {
+	for i, stage := range p.stages {
+		if err := stage.GetError(); err != nil {
+			return err
+		}
+		for _, decoder := range stage.decoders {
+			decoder.mutex.Lock()
+			err, excerpt, records := decoder.err, decoder.excerpt, decoder.records
+			decoder.mutex.Unlock()
+			if err != nil {
+				return DecodeFailure{
+					Format:          decoder.format,
+					Record:          records + 1,
+					Excerpt:         excerpt,
+					Cause:           err,
+					FailureExitCode: p.stageFailure(i),
+				}
+			}
+		}
+	}
+	failed := p.failedStages()
+	if len(failed) == 0 {
+		return nil
+	}
+	for _, i := range failed {
+		if p.cpuLimitExceeded(i) {
+			return ResourceLimitExceeded{Resource: "cpu", Limit: *p.cmdts[i].Rlimits.CPU, FailureExitCode: p.stageFailure(i)}
+		}
+		if stats := p.stages[i].GetCgroupStats(); stats != nil && stats.OomKills > 0 {
+			err := OutOfMemory{OomKills: stats.OomKills, FailureExitCode: p.stageFailure(i)}
+			if p.cmdts[i].Cgroup.MemoryMax != nil {
+				err.Limit = *p.cmdts[i].Cgroup.MemoryMax
+			}
+			return err
+		}
+	}
+	if len(p.stages) == 1 {
+		return p.stageFailure(0)
+	}
+	err := PipelineFailure{stages: failed}
+	for _, i := range failed {
+		err.failures = append(err.failures, p.stageFailure(i))
+	}
+	return err
+}
+
+/**
+ * Whether a stage was killed for using up its CPU limit: either by the SIGXCPU at the
+ * soft limit, or, if it ignored that, by the SIGKILL at the hard limit.  A SIGKILL only
+ * counts if the stage had used at least its limit, since it could have come from anywhere.
+ */
+func (p *RunningPipeline) cpuLimitExceeded(i int) bool {
+	rlimits := p.cmdts[i].Rlimits
+	if rlimits == nil || rlimits.CPU == nil {
+		return false
+	}
+	switch p.stages[i].GetSignal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		usage := p.stages[i].GetUsage()
+		return usage != nil && usage.UserTime+usage.SystemTime >= time.Duration(*rlimits.CPU)*time.Second
+	default:
+		return false
+	}
+}
+
+/** How much of stderr to keep for reporting in a FailureExitCode. */
+const stderrTailLimit = 4096
+
+/**
+ * How long to wait for stderr to be drained after the command has exited.  Anything
+ * the command itself wrote is already in the pipe by then; this only matters if something
+ * it left running in the background still holds stderr open, and we won't wait on that.
+ */
+const stderrDrainGrace = 100 * time.Millisecond
+
+/**
+ * Copies a command's stderr through a pipe to where it was going (if anywhere), keeping
+ * the end of it in a tailWriter on the way.
+ */
+type stderrTee struct {
+	tail *tailWriter
+	dst  io.Writer
+
+	/** Set once we stop waiting on the tee; anything read after that is discarded. */
+	detached bool
+	mutex    sync.Mutex
+
+	/** Closed once everything holding the pipe open has closed it. */
+	done chan bool
+}
+
+/**
+ * Tees the command's stderr into a tailWriter, so the end of it can be reported if
+ * the command fails.  Returns nil and leaves the command alone if stderr is a file
+ * (the command gets the descriptor itself, just as it would without the tee), is shared
+ * with stdout (teeing would split them into two streams), or goes to a Tty.  If stderr
+ * wasn't going anywhere, it's read into the tailWriter alone.
+ *
+ * The command is given the write side of an OS pipe, which is added to closeAfterStart.
+ * The tee must be closed once the command exits; see Close().
+ */
+func captureStderrTail(cmd *RunningCommand) (*stderrTee, error) {
+	rcmd := cmd.cmd
+	if cmd.tty != nil {
+		return nil, nil
+	}
+	if rcmd.Stderr != nil {
+		if _, ok := rcmd.Stderr.(*os.File); ok || sameWriter(rcmd.Stderr, rcmd.Stdout) {
+			return nil, nil
+		}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	tee := &stderrTee{
+		tail: newTailWriter(stderrTailLimit),
+		dst:  rcmd.Stderr,
+		done: make(chan bool),
+	}
+	go tee.copy(r)
+	rcmd.Stderr = w
+	cmd.closeAfterStart = append(cmd.closeAfterStart, w)
+	return tee, nil
+}
+
+func (tee *stderrTee) copy(r *os.File) {
+	defer close(tee.done)
+	defer r.Close()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			tee.mutex.Lock()
+			if !tee.detached {
+				tee.tail.Write(buf[:n])
+				if tee.dst != nil {
+					tee.dst.Write(buf[:n])
+				}
+			}
+			tee.mutex.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+/**
+ * Waits for the pipe to be drained, or for stderrDrainGrace, whichever is first.
+ * After that, anything still holding the pipe open can keep on writing to it, but what
+ * it writes is discarded: it doesn't reach the tail or the original stderr, which the
+ * caller may well have moved on to using by then.
+ */
+func (tee *stderrTee) Close() error {
+	select {
+	case <-tee.done:
+	case <-time.After(stderrDrainGrace):
+	}
+	tee.mutex.Lock()
+	defer tee.mutex.Unlock()
+	tee.detached = true
+	return nil
+}
+
+/**
+ * Describes the exit of a stage as a FailureExitCode.
+ */
+func (p *RunningPipeline) stageFailure(i int) FailureExitCode {
+	stage, cmdt := p.stages[i], p.cmdts[i]
+	err := FailureExitCode{
+		Cmdname:  cmdt.cmd,
+		Args:     cmdt.args,
+		Cwd:      cmdt.Cwd,
+		Code:     stage.GetExitCode(),
+		Signal:   stage.GetSignal(),
+		Duration: stage.GetDuration(),
+	}
+	if err.Cwd == "" {
+		err.Cwd, _ = os.Getwd()
+	}
+	if stage.stderrTail != nil {
+		err.StderrTail = stage.stderrTail.String()
+	}
+	return err
+}
+
+func isOkExit(code int, okExit []int) bool {
+	for _, okcode := range okExit {
+		if code == okcode {
+			return true
+		}
+	}
+	return false
+}
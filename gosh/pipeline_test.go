@@ -0,0 +1,141 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"testing"
+)
+
+func TestPipelineExitCodes(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	first := Sh("bash")("-c", "exit 3")
+	second := Sh("bash")("-c", "cat; exit 4")(Opts{In: first})
+	p := Sh("cat")(Opts{In: second}).StartPipeline()
+
+	assert.Equal(
+		3,
+		len(p.Stages()),
+	)
+	assert.Equal(
+		[]int{3, 4, 0},
+		p.GetExitCodes(),
+	)
+	assert.Equal(
+		0,
+		p.GetExitCode(),
+	)
+	assert.Equal(
+		0,
+		len(p.failedStages()),
+	)
+}
+
+func TestPipelinePipefail(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	first := Sh("bash")("-c", "exit 3")
+	second := Sh("bash")("-c", "cat; exit 4")(Opts{In: first})
+	p := Sh("cat")(Opts{In: second, Pipefail: PIPEFAIL}).StartPipeline()
+
+	assert.Equal(
+		4,
+		p.GetExitCode(),
+	)
+	assert.Equal(
+		"sh: pipeline failed: stage 1 (\"bash\") exited with unexpected status 4",
+		p.failure().Error(),
+	)
+}
+
+func TestPipelinePolicyCanBeReset(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	first := Sh("bash")("-c", "exit 3")
+	strict := Sh("cat")(Opts{In: first, Pipefail: PIPEFAIL})
+	assert.Equal(
+		PIPEFAIL,
+		strict.StartPipeline().Policy(),
+	)
+	assert.Equal(
+		LAST_STAGE,
+		strict(Opts{Pipefail: LAST_STAGE}).StartPipeline().Policy(),
+	)
+	assert.Equal(
+		LAST_STAGE,
+		Sh("cat")(Opts{In: first}).StartPipeline().Policy(),
+	)
+}
+
+func TestPipelineAllStages(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	first := Sh("bash")("-c", "exit 3")
+	second := Sh("bash")("-c", "cat; exit 4")(Opts{In: first})
+	p := Sh("cat")(Opts{In: second, Pipefail: ALL_STAGES}).StartPipeline()
+
+	assert.Equal(
+		[]int{0, 1},
+		p.failure().(PipelineFailure).FailedStages(),
+	)
+	assert.Equal(
+		"sh: pipeline failed: stage 0 (\"bash\") exited with unexpected status 3; stage 1 (\"bash\") exited with unexpected status 4",
+		p.failure().Error(),
+	)
+}
+
+func TestPipelineStageOkExit(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	first := Sh("bash")("-c", "exit 3")(Opts{OkExit: []int{3}})
+	p := Sh("cat")(Opts{In: first, Pipefail: ALL_STAGES}).StartPipeline()
+
+	assert.Equal(
+		nil,
+		p.failure(),
+	)
+}
+
+func TestPipelineRunPanicsWithPipelineFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	defer func() {
+		err := recover()
+		failure, ok := err.(PipelineFailure)
+		assert.Equal(
+			true,
+			ok,
+		)
+		assert.Equal(
+			[]int{1},
+			failure.FailedStages(),
+		)
+	}()
+	Sh("bash")("-c", "cat; exit 5")(Opts{In: Sh("echo")})()
+}
+
+func TestSingleStageRunPanicsWithFailureExitCode(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	defer func() {
+		err := recover()
+		assert.Equal(
+			5,
+			err.(FailureExitCode).Code,
+		)
+	}()
+	Sh("bash")("-c", "exit 5")()
+}
@@ -15,20 +15,149 @@
 package gosh
 
 import (
+	"io"
+	"io/ioutil"
 	"os"
+	"polydawn.net/pogo/iox"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
 )
 
 /**
- * Why the golang stdlib doesn't already expose environ as a map from
- * string to string -- beacuse that's WHAT IT IS -- is so far beyond my
- * understanding...
+ * Adapts a LineWriter to be flushed, but not closed, wherever a Closer is wanted.
  */
-func getOsEnv() map[string]string {
-	env := make(map[string]string)
-	for _, line := range os.Environ() {
-		chunks := strings.SplitN(line, "=", 2)
-		env[chunks[0]] = chunks[1]
+type flusher struct {
+	*iox.LineWriter
+}
+
+func (f flusher) Close() error {
+	return f.Flush()
+}
+
+/**
+ * How long a process group has to outlast its leader before we start looking through
+ * /proc for zombies, and the longest we sleep between checks on it.
+ */
+const (
+	groupScanAfter = 100 * time.Millisecond
+	groupPollMax   = time.Second
+)
+
+/**
+ * Waits until no process in the group is alive.
+ *
+ * There's no waiting on processes that aren't our children, so this polls: often at
+ * first, since most groups are gone soon after their leader, and backing off to once
+ * every groupPollMax for groups that aren't.
+ *
+ * Zombies still count as members of their group until somebody reaps them, and
+ * whether that ever happens is up to whatever init the orphans got reparented to;
+ * so if the group is still around after groupScanAfter, we also look through /proc
+ * (where it's available) for a member that isn't a zombie.
+ */
+func waitForGroup(pgid int) {
+	start := time.Now()
+	interval := 10 * time.Millisecond
+	for {
+		if err := syscall.Kill(-pgid, 0); err == syscall.ESRCH {
+			return
+		}
+		if time.Since(start) >= groupScanAfter && !groupHasLiving(pgid) {
+			return
+		}
+		time.Sleep(interval)
+		if interval *= 2; interval > groupPollMax {
+			interval = groupPollMax
+		}
 	}
-	return env
+}
+
+/**
+ * Returns true if any process in the group is alive and not a zombie, or if we can't tell.
+ */
+func groupHasLiving(pgid int) bool {
+	proc, err := os.Open("/proc")
+	if err != nil {
+		return true
+	}
+	defer proc.Close()
+	names, err := proc.Readdirnames(-1)
+	if err != nil {
+		return true
+	}
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile("/proc/" + name + "/stat")
+		if err != nil {
+			continue
+		}
+		// the command name in parens can contain anything, so skip past the last paren.
+		// what's left is "state ppid pgrp ...".
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		if len(fields) < 3 {
+			continue
+		}
+		if fields[2] == strconv.Itoa(pgid) && fields[0] != "Z" {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * A writer that keeps only the last limit bytes written to it.
+ */
+type tailWriter struct {
+	mutex sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func newTailWriter(limit int) *tailWriter {
+	return &tailWriter{limit: limit}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(p) >= w.limit {
+		w.buf = append(w.buf[:0], p[len(p)-w.limit:]...)
+	} else {
+		if over := len(w.buf) + len(p) - w.limit; over > 0 {
+			w.buf = append(w.buf[:0], w.buf[over:]...)
+		}
+		w.buf = append(w.buf, p...)
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return string(w.buf)
+}
+
+/**
+ * Compares two writers, without panicking if they're of a type that can't be compared.
+ */
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
 }
@@ -0,0 +1,75 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os"
+	"path/filepath"
+)
+
+/**
+ * A file to use as In, Out, or Err, by path.  (A plain string would be taken as
+ * the content of the input, not the name of a file.)  Make one with FromFile(),
+ * ToFile(), or AppendFile(), or use DevNull.
+ *
+ * The file is opened when the command is started, and the command gets the
+ * descriptor itself: nothing is copied through this process.  Our copy is closed
+ * as soon as the command has started, so the file is closed for good when the
+ * command (and anything it left holding the file) exits.
+ *
+ * A relative Path is relative to the command's Cwd, and isn't allowed at all if
+ * the command is chrooted by Isolation.Root.  If Out and Err are the same
+ * FileRedirect, they share one descriptor, as with `> file 2>&1`.
+ */
+type FileRedirect struct {
+	Path string
+
+	/** Flags for os.OpenFile, e.g. os.O_WRONLY|os.O_CREATE|os.O_TRUNC. */
+	Flag int
+
+	/** Permissions for the file, if it's created. */
+	Perm os.FileMode
+}
+
+/** Reads input from a file, like `< path`. */
+func FromFile(path string) FileRedirect {
+	return FileRedirect{Path: path, Flag: os.O_RDONLY}
+}
+
+/** Writes output to a file, truncating it if it exists, or creating it with the given permissions, like `> path`. */
+func ToFile(path string, perm os.FileMode) FileRedirect {
+	return FileRedirect{Path: path, Flag: os.O_WRONLY | os.O_CREATE | os.O_TRUNC, Perm: perm}
+}
+
+/** Appends output to a file, creating it if need be, like `>> path`. */
+func AppendFile(path string) FileRedirect {
+	return FileRedirect{Path: path, Flag: os.O_WRONLY | os.O_CREATE | os.O_APPEND, Perm: 0666}
+}
+
+/** Reads nothing, or discards everything written, depending on whether it's used as input or output. */
+var DevNull = FileRedirect{Path: os.DevNull, Flag: os.O_RDWR}
+
+/**
+ * Stands in for Opts.Err to send stderr wherever stdout ends up going, like `2>&1`.
+ */
+type stderrToStdout struct{}
+
+func (redirect FileRedirect) open(cwd string) (*os.File, error) {
+	path := redirect.Path
+	if cwd != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	return os.OpenFile(path, redirect.Flag, redirect.Perm)
+}
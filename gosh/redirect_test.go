@@ -0,0 +1,98 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRedirects(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	dir, err := ioutil.TempDir("", "gosh-redirect-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "in"), []byte("from a file\n"), 0644))
+
+	Sh("cat")(Opts{Cwd: dir, In: FromFile("in"), Out: ToFile("out", 0600)}).Run()
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.Equal(
+		"from a file\n",
+		string(content),
+	)
+	info, _ := os.Stat(filepath.Join(dir, "out"))
+	assert.Equal(
+		os.FileMode(0600),
+		info.Mode().Perm(),
+	)
+
+	log := AppendFile(filepath.Join(dir, "out"))
+	Sh("sh")("-c", "echo out; echo err >&2")(Opts{Out: log, Err: log}).Run()
+	content, _ = ioutil.ReadFile(filepath.Join(dir, "out"))
+	assert.Equal(
+		"from a file\nout\nerr\n",
+		string(content),
+	)
+}
+
+func TestFileRedirectsAreGivenDirectly(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	dir, err := ioutil.TempDir("", "gosh-redirect-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out")
+
+	// the command's stdout is the file itself, not a pipe to a goroutine of ours.
+	Sh("readlink")("/proc/self/fd/1")(Opts{Out: ToFile(path, 0644)}).Run()
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(
+		path+"\n",
+		string(content),
+	)
+}
+
+func TestDevNull(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	assert.Equal(
+		"",
+		Sh("cat")(Opts{In: DevNull}).Output(),
+	)
+	assert.Equal(
+		"",
+		Sh("sh")("-c", "echo lost >&2")(Opts{Err: DevNull}).Output(),
+	)
+	Sh("echo")("lost")(Opts{Out: DevNull}).Run()
+}
+
+func TestFileRedirectsDontLeak(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	before, _ := ioutil.ReadDir("/proc/self/fd")
+	for i := 0; i < 10; i++ {
+		Sh("cat")(Opts{In: DevNull, Out: DevNull, Err: DevNull}).Run()
+		Sh("cat")(Opts{In: FromFile("/thishadbetternotexist"), Out: DevNull}).RunE()
+	}
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	assert.Equal(
+		len(before),
+		len(after),
+	)
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+)
+
+/**
+ * Resource limits to apply to a command, as by setrlimit.  Set as Opts.Rlimits.
+ *
+ * Each limit is set as both the soft and the hard limit (except for CPU; see below),
+ * and applies only to the command and whatever it starts, never to this process.
+ * Limits left nil are inherited as usual.  Use Limit() to fill them in, e.g.
+ *   `Rlimits{CPU: Limit(10), CoreSize: Limit(0)}`
+ *
+ * The limits are set by starting the command via /bin/sh, which sets them with ulimit
+ * and then execs the command; this has the same requirements and effect on argv[0] as
+ * Opts.Umask.  If a limit can't be set (e.g. it's above the hard limit we have, and
+ * we're not privileged), the command isn't run at all, and fails with the shell's
+ * complaint on stderr.
+ */
+type Rlimits struct {
+	/**
+	 * CPU time, in seconds.  The command receives SIGXCPU at the limit, and is
+	 * killed (by SIGKILL) if it's still going a second later.  A command that dies
+	 * either way is reported by Run() as ResourceLimitExceeded, as long as it counts
+	 * as failed under the pipeline's PipefailPolicy.
+	 */
+	CPU *uint64
+
+	/** Size of the virtual address space, in bytes (rounded down to a whole kilobyte). */
+	AddressSpace *uint64
+
+	/** Number of open file descriptors. */
+	OpenFiles *uint64
+
+	/**
+	 * Number of processes (counted per user, not per command; and not enforced at
+	 * all for privileged users).
+	 */
+	Processes *uint64
+
+	/**
+	 * Size of core dump files, in bytes (rounded down to a whole 512-byte block).
+	 * Zero disables core dumps.
+	 */
+	CoreSize *uint64
+}
+
+func Limit(n uint64) *uint64 {
+	return &n
+}
+
+/**
+ * Returns the ulimit commands that apply the limits, for a trampoline.  Units are as
+ * POSIX specifies for sh (which bash observes in its posix mode, as /bin/sh).
+ */
+func (rlimits *Rlimits) ulimits(sh shellFeatures) ([]string, error) {
+	var cmds []string
+	if rlimits.CPU != nil {
+		// the hard limit has to be set first, or the soft limit can't be raised past it.
+		cmds = append(cmds,
+			fmt.Sprintf("ulimit -t %d", *rlimits.CPU+1),
+			fmt.Sprintf("ulimit -S -t %d", *rlimits.CPU),
+		)
+	}
+	if rlimits.AddressSpace != nil {
+		cmds = append(cmds, fmt.Sprintf("ulimit -v %d", *rlimits.AddressSpace/1024))
+	}
+	if rlimits.OpenFiles != nil {
+		cmds = append(cmds, fmt.Sprintf("ulimit -n %d", *rlimits.OpenFiles))
+	}
+	if rlimits.Processes != nil {
+		if sh.nprocFlag == "" {
+			return nil, fmt.Errorf("%s has no ulimit option for the number of processes", trampolineShell)
+		}
+		cmds = append(cmds, fmt.Sprintf("ulimit %s %d", sh.nprocFlag, *rlimits.Processes))
+	}
+	if rlimits.CoreSize != nil {
+		cmds = append(cmds, fmt.Sprintf("ulimit -c %d", *rlimits.CoreSize/512))
+	}
+	return cmds, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"syscall"
+	"testing"
+)
+
+func TestRlimitsApplyToChildOnly(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	var before syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &before)
+
+	out := Sh("bash")("-c", "ulimit -n; ulimit -c; ulimit -v; ulimit -u; ulimit -t; ulimit -H -t")(Opts{Rlimits: &Rlimits{
+		OpenFiles:    Limit(64),
+		CoreSize:     Limit(0),
+		AddressSpace: Limit(1 << 30),
+		Processes:    Limit(500),
+		CPU:          Limit(100),
+	}}).Output()
+	assert.Equal(
+		"64\n0\n1048576\n500\n100\n101\n",
+		out,
+	)
+
+	var after syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after)
+	assert.Equal(
+		before,
+		after,
+	)
+}
+
+func TestRlimitsCPUExceeded(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	err := Sh("bash")("-c", "while true; do :; done")(Opts{Rlimits: &Rlimits{CPU: Limit(1)}}).RunE()
+	exceeded, ok := err.(ResourceLimitExceeded)
+	assert.Equal(
+		true,
+		ok,
+	)
+	assert.Equal(
+		"cpu",
+		exceeded.Resource,
+	)
+	assert.Equal(
+		152,
+		exceeded.Code,
+	)
+}
+
+func TestRlimitsCPUExceededIgnoringSIGXCPU(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// killed at the hard limit instead.
+	err := Sh("bash")("-c", "trap '' XCPU; while true; do :; done")(Opts{Rlimits: &Rlimits{CPU: Limit(1)}}).RunE()
+	exceeded, ok := err.(ResourceLimitExceeded)
+	assert.Equal(
+		true,
+		ok,
+	)
+	assert.Equal(
+		137,
+		exceeded.Code,
+	)
+}
+
+func TestRlimitsCPUExceededFollowsPipefail(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// the first stage isn't checked under LAST_STAGE, so it doesn't matter how it died.
+	spin := Sh("bash")("-c", "while true; do :; done")(Opts{Rlimits: &Rlimits{CPU: Limit(1)}})
+	err := Sh("cat")(Opts{In: spin}).RunE()
+	assert.Nil(err)
+
+	// and a stage whose exit is ok doesn't count either.
+	err = Sh("bash")("-c", "while true; do :; done")(Opts{Rlimits: &Rlimits{CPU: Limit(1)}, OkExit: []int{0, 152}}).RunE()
+	assert.Nil(err)
+}
+
+func TestRlimitsOpenFilesExceeded(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	// not signal-enforced, so this is just an ordinary failure.
+	err := Sh("bash")("-c", "exec 20</dev/null")(Opts{Rlimits: &Rlimits{OpenFiles: Limit(10)}}).RunE()
+	_, ok := err.(FailureExitCode)
+	assert.Equal(
+		true,
+		ok,
+	)
+}
+
+func TestRlimitsUlimits(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	rlimits := &Rlimits{CPU: Limit(10), AddressSpace: Limit(1 << 20), Processes: Limit(50), CoreSize: Limit(1024)}
+	cmds, err := rlimits.ulimits(shellFeatures{nprocFlag: "-p"})
+	assert.Nil(err)
+	assert.Equal(
+		[]string{"ulimit -t 11", "ulimit -S -t 10", "ulimit -v 1024", "ulimit -p 50", "ulimit -c 2"},
+		cmds,
+	)
+
+	_, err = rlimits.ulimits(shellFeatures{})
+	assert.NotNil(err)
+}
@@ -15,16 +15,19 @@
 package gosh
 
 import (
-	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"polydawn.net/pogo/iox"
+	"syscall"
 )
 
 func Sh(cmd string) Command {
 	var cmdt commandTemplate
 	cmdt.cmd = cmd
-	cmdt.env = getOsEnv()
+	cmdt.env = osEnvironment()
 	cmdt.OkExit = []int{0}
 	return enclose(&cmdt)
 }
@@ -62,8 +65,18 @@ func closure(cmdt commandTemplate, args ...interface{}) Command {
 				cmdt.bakeEnv(arg)
 			case ClearEnv:
 				cmdt.clearEnv()
+			case KeepEnv:
+				cmdt.env = cmdt.env.only(arg)
+			case EnvExpand:
+				cmdt.env = cmdt.env.apply(cmdt.env.expand(arg))
+			case PrependPath:
+				cmdt.env = cmdt.env.withPath(string(arg), true)
+			case AppendPath:
+				cmdt.env = cmdt.env.withPath(string(arg), false)
 			case Opts:
 				cmdt.bakeOpts(arg)
+			case Context:
+				cmdt.bakeContext(arg)
 			default:
 				panic(IncomprehensibleCommandModifier{wat: &rarg})
 			}
@@ -98,13 +111,7 @@ func (f Command) BakeEnv(args Env) Command {
 }
 
 func (cmdt *commandTemplate) bakeEnv(args Env) *commandTemplate {
-	for k, v := range args {
-		if v == "" {
-			delete(cmdt.env, k)
-		} else {
-			cmdt.env[k] = v
-		}
-	}
+	cmdt.env = cmdt.env.apply(args)
 	return cmdt
 }
 
@@ -113,10 +120,27 @@ func (f Command) ClearEnv() Command {
 }
 
 func (cmdt *commandTemplate) clearEnv() *commandTemplate {
-	cmdt.env = make(map[string]string)
+	cmdt.env = environment{}
 	return cmdt
 }
 
+/**
+ * Returns the environment the command will be run with, as "KEY=value" entries in the
+ * order they'll be passed to it.
+ */
+func (f Command) Environ() []string {
+	env := f.expose().env
+	return append(make([]string, 0, len(env)), env...)
+}
+
+/**
+ * Returns the value of a variable in the environment the command will be run with,
+ * and whether it's set at all.
+ */
+func (f Command) LookupEnv(key string) (string, bool) {
+	return f.expose().env.lookup(key)
+}
+
 func (f Command) BakeOpts(args ...Opts) Command {
 	return enclose(f.expose().bakeOpts(args...))
 }
@@ -138,63 +162,335 @@ func (cmdt *commandTemplate) bakeOpts(args ...Opts) *commandTemplate {
 		if arg.OkExit != nil {
 			cmdt.OkExit = arg.OkExit
 		}
+		if arg.Pipefail != 0 {
+			cmdt.Pipefail = arg.Pipefail
+		}
+		if arg.ProcessGroup != 0 {
+			cmdt.ProcessGroup = arg.ProcessGroup
+		}
+		if arg.Timeout != 0 {
+			cmdt.Timeout = arg.Timeout
+		}
+		if arg.KillGrace != 0 {
+			cmdt.KillGrace = arg.KillGrace
+		}
+		if arg.Tty != nil {
+			cmdt.Tty = arg.Tty
+		}
+		if arg.Rlimits != nil {
+			cmdt.Rlimits = arg.Rlimits
+		}
+		if arg.Credential != nil {
+			cmdt.Credential = arg.Credential
+		}
+		if arg.Umask != nil {
+			cmdt.Umask = arg.Umask
+		}
+		if arg.Isolation != nil {
+			cmdt.Isolation = arg.Isolation
+		}
+		if arg.Cgroup != nil {
+			cmdt.Cgroup = arg.Cgroup
+		}
+		if arg.Capture != nil {
+			cmdt.Capture = arg.Capture
+		}
 	}
 	return cmdt
 }
 
+func (f Command) BakeContext(ctx Context) Command {
+	return enclose(f.expose().bakeContext(ctx))
+}
+
+func (cmdt *commandTemplate) bakeContext(ctx Context) *commandTemplate {
+	cmdt.ctx = &ctx
+	return cmdt
+}
+
 /**
  * Starts execution of the command.  Returns a reference to a RunningCommand,
  * which can be used to track execution of the command, configure exit listeners,
  * etc.
+ *
+ * If the command's input is another Command, that command (and any command piped
+ * into it, and so on) is started along with this one, connected by an OS pipe.
+ * The returned RunningCommand is the last stage of the pipeline, and it will not
+ * be considered done until every stage before it has also exited.  Use
+ * StartPipeline() instead to get a handle on every stage.
+ *
+ * If the command cannot be started, a panic of type CommandStartError will be
+ * emitted; use StartE() to get it as an error instead.
  */
 func (f Command) Start() *RunningCommand {
+	return f.StartPipeline().Last()
+}
+
+/**
+ * Same as Start(), but returns a CommandStartError instead of panicking if the
+ * command cannot be started.
+ */
+func (f Command) StartE() (*RunningCommand, error) {
+	p, err := f.StartPipelineE()
+	if err != nil {
+		return nil, err
+	}
+	return p.Last(), nil
+}
+
+/**
+ * Starts execution of the command, and of any commands piped into it.  Returns a
+ * RunningPipeline, which can be used to track every stage of the pipeline.
+ *
+ * A command that doesn't have another Command as its input is started as a pipeline
+ * of only one stage.
+ */
+func (f Command) StartPipeline() *RunningPipeline {
+	p, err := f.StartPipelineE()
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+/**
+ * Same as StartPipeline(), but returns a CommandStartError instead of panicking if
+ * any stage cannot be started.  Stages that were already started when the error
+ * was encountered are killed.
+ */
+func (f Command) StartPipelineE() (*RunningPipeline, error) {
+	// walk back along any commands piped in as input, so the whole pipeline can be started together.
 	cmdt := f.expose()
-	rcmd := exec.Command(cmdt.cmd, cmdt.args...)
+	cmdts := []*commandTemplate{cmdt}
+	for {
+		upstream, ok := cmdt.In.(Command)
+		if !ok {
+			break
+		}
+		cmdt = upstream.expose()
+		cmdts = append([]*commandTemplate{cmdt}, cmdts...)
+	}
 
-	// set up env
-	if cmdt.env != nil {
-		rcmd.Env = make([]string, len(cmdt.env))
-		i := 0
-		for k, v := range cmdt.env {
-			rcmd.Env[i] = fmt.Sprintf("%s=%s", k, v)
-			i++
+	// the children get pipes and redirected files as descriptors directly; nothing is copied through this process.
+	// our copies of the descriptors must be closed once the children have them, or readers will never see EOF.
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
 		}
+	}()
+
+	rcmds := make([]*exec.Cmd, len(cmdts))
+	for i, cmdt := range cmdts {
+		rcmd, opened, err := cmdt.prepare()
+		files = append(files, opened...)
+		if err != nil {
+			return nil, CommandStartError{cause: err}
+		}
+		rcmds[i] = rcmd
+	}
+
+	// connect each stage's stdout to the next stage's stdin.
+	for i := 1; i < len(rcmds); i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, CommandStartError{cause: err}
+		}
+		files = append(files, r, w)
+		if cmdts[i-1].Err != nil && (cmdts[i-1].Err == cmdts[i-1].Out || cmdts[i-1].Err == stderrToStdout{}) {
+			rcmds[i-1].Stderr = w
+		}
+		rcmds[i-1].Stdout = w
+		rcmds[i].Stdin = r
 	}
 
+	// go time
+	p := &RunningPipeline{
+		stages: make([]*RunningCommand, len(rcmds)),
+		cmdts:  cmdts,
+		policy: cmdts[len(cmdts)-1].Pipefail,
+	}
+	if p.policy == 0 {
+		p.policy = LAST_STAGE
+	}
+	var upstream *RunningCommand
+	for i, rcmd := range rcmds {
+		cmd := NewRunningCommand(rcmd)
+		cmd.upstream = upstream
+		cmd.ctx = cmdts[i].ctx
+		if cmd.ctx == nil {
+			cmd.ctx = cmdts[len(cmdts)-1].ctx
+		}
+		cmd.tty = cmdts[i].Tty
+		cmd.rlimits = cmdts[i].Rlimits
+		cmd.umask = cmdts[i].Umask
+		cmd.cgroup = cmdts[i].Cgroup
+		switch cmdts[i].In.(type) {
+		case iox.TemplateInput, iox.MultiInput:
+			// if the command exits without reading all of its input, the rest would never be rendered.
+			cmd.closeAfterExit = append(cmd.closeAfterExit, rcmd.Stdin.(io.Closer))
+		}
+		for _, w := range []io.Writer{rcmd.Stdout, rcmd.Stderr} {
+			switch w := w.(type) {
+			case *iox.LineWriter:
+				// the last line of output needn't end in a line break.
+				cmd.closeAfterExit = append(cmd.closeAfterExit, flusher{w})
+			case *RecordWriter:
+				cmd.closeAfterExit = append(cmd.closeAfterExit, w)
+				cmd.decoders = append(cmd.decoders, w)
+			}
+		}
+		tee, err := captureStderrTail(cmd)
+		if err != nil {
+			for _, started := range p.stages[:i] {
+				started.Kill()
+			}
+			return nil, CommandStartError{cause: err}
+		}
+		if tee != nil {
+			cmd.stderrTail = tee.tail
+			// the tee has to be done before anything it writes into is flushed.
+			cmd.closeAfterExit = append([]io.Closer{tee}, cmd.closeAfterExit...)
+		}
+		cmd.timeout, cmd.timeoutGrace = cmdts[i].Timeout, cmdts[i].KillGrace
+		if cmd.timeout == 0 {
+			cmd.timeout, cmd.timeoutGrace = cmdts[len(cmdts)-1].Timeout, cmdts[len(cmdts)-1].KillGrace
+		}
+		p.stages[i] = cmd
+		if err := cmd.startCalmly(); err != nil {
+			for _, started := range p.stages[:i] {
+				started.Kill()
+			}
+			return nil, err
+		}
+		upstream = cmd
+	}
+	return p, nil
+}
+
+/**
+ * Same as iox.WriterFromInterface, except that string channels get one line per message.
+ */
+func writerFromInterface(x interface{}) io.Writer {
+	switch ch := x.(type) {
+	case chan<- string:
+		return iox.WriterToChanLines(ch)
+	case chan string:
+		return iox.WriterToChanLines(ch)
+	default:
+		return iox.WriterFromInterface(x)
+	}
+}
+
+/**
+ * Produces an exec.Cmd configured with the command's args, env, and opts.
+ * If the input is another Command, stdin is left unset; connecting
+ * pipelines is up to the caller.
+ *
+ * Also returns any files opened for redirections, which the caller should close
+ * once the command is started (or fails to), even if there's an error.
+ */
+func (cmdt *commandTemplate) prepare() (*exec.Cmd, []*os.File, error) {
+	rcmd := exec.Command(cmdt.cmd, cmdt.args...)
+
+	// set up env.  never nil, because exec would take that to mean our own.
+	rcmd.Env = append(make([]string, 0, len(cmdt.env)), cmdt.env...)
+
 	// set up opts (cwd/stdin/stdout/stderr)
 	if cmdt.Cwd != "" {
 		rcmd.Dir = cmdt.Cwd
 	}
+	var files []*os.File
+	redirect := func(redirect FileRedirect) (*os.File, error) {
+		if cmdt.Isolation != nil && cmdt.Isolation.Root != "" && !filepath.IsAbs(redirect.Path) {
+			// Cwd is inside the root, but we open the file out here.
+			return nil, fmt.Errorf("redirect to relative path %q can't be used with Isolation.Root", redirect.Path)
+		}
+		f, err := redirect.open(cmdt.Cwd)
+		if err == nil {
+			files = append(files, f)
+		}
+		return f, err
+	}
 	if cmdt.In != nil {
 		switch in := cmdt.In.(type) {
 		case Command:
-			//TODO something marvelous
-			panic(fmt.Errorf("not yet implemented"))
+			// piped in by Start()
+		case FileRedirect:
+			f, err := redirect(in)
+			if err != nil {
+				return nil, files, err
+			}
+			rcmd.Stdin = f
 		default:
 			rcmd.Stdin = iox.ReaderFromInterface(in)
 		}
 	}
 	if cmdt.Out != nil {
-		rcmd.Stdout = iox.WriterFromInterface(cmdt.Out)
+		switch out := cmdt.Out.(type) {
+		case FileRedirect:
+			f, err := redirect(out)
+			if err != nil {
+				return nil, files, err
+			}
+			rcmd.Stdout = f
+		default:
+			rcmd.Stdout = writerFromInterface(out)
+		}
 	}
 	if cmdt.Err != nil {
-		if cmdt.Err == cmdt.Out {
+		switch errOut := cmdt.Err.(type) {
+		case stderrToStdout:
 			rcmd.Stderr = rcmd.Stdout
-		} else {
-			rcmd.Stderr = iox.WriterFromInterface(cmdt.Err)
+		case FileRedirect:
+			if cmdt.Err == cmdt.Out {
+				rcmd.Stderr = rcmd.Stdout
+				break
+			}
+			f, err := redirect(errOut)
+			if err != nil {
+				return nil, files, err
+			}
+			rcmd.Stderr = f
+		default:
+			if cmdt.Err == cmdt.Out {
+				rcmd.Stderr = rcmd.Stdout
+			} else {
+				rcmd.Stderr = writerFromInterface(errOut)
+			}
 		}
 	}
 
-	// go time
-	cmd := NewRunningCommand(rcmd)
-	cmd.Start()
-	return cmd
+	// set up process attributes
+	switch cmdt.ProcessGroup {
+	case NEW_PROCESS_GROUP:
+		sysProcAttr(rcmd).Setpgid = true
+	case NEW_SESSION:
+		sysProcAttr(rcmd).Setsid = true
+	}
+	if cmdt.Credential != nil {
+		sysProcAttr(rcmd).Credential = cmdt.Credential.sys()
+	}
+	if cmdt.Isolation != nil {
+		cmdt.Isolation.applyTo(sysProcAttr(rcmd))
+	}
+	return rcmd, files, nil
+}
+
+func sysProcAttr(rcmd *exec.Cmd) *syscall.SysProcAttr {
+	if rcmd.SysProcAttr == nil {
+		rcmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return rcmd.SysProcAttr
 }
 
 /**
  * Starts execution of the command, and waits until completion before returning.
  * If the command does not execute successfully, a panic of type FailureExitCode
  * will be emitted; use Opts.OkExit to configure what is considered success.
+ * If the command is a pipeline, a panic of type PipelineFailure listing the failed
+ * stages will be emitted instead; use Opts.Pipefail to configure which stages are
+ * considered.
  *
  * The is exactly the behavior of a no-arg invokation on an Command, i.e.
  *   `Sh("echo")()`
@@ -206,16 +502,25 @@ func (f Command) Start() *RunningCommand {
  * if you otherwise need greater control over execution.
  */
 func (f Command) Run() {
-	cmdt := f.expose()
-	cmd := f.Start()
-	cmd.Wait()
-	exitCode := cmd.GetExitCode()
-	for _, okcode := range cmdt.OkExit {
-		if exitCode == okcode {
-			return
-		}
+	if err := f.RunE(); err != nil {
+		panic(err)
 	}
-	panic(FailureExitCode{cmdname: cmdt.cmd, code: exitCode})
+}
+
+/**
+ * Same as Run(), but returns errors instead of panicking.
+ *
+ * The error will be a CommandStartError if the command could not be started,
+ * a CommandMonitorError if the command could not be waited on, and a
+ * FailureExitCode (or PipelineFailure) if the command did not execute successfully.
+ */
+func (f Command) RunE() error {
+	p, err := f.StartPipelineE()
+	if err != nil {
+		return err
+	}
+	p.Wait()
+	return p.failure()
 }
 
 /**
@@ -230,16 +535,62 @@ func (f Command) Run() {
  * stderr will go.
  */
 func (f Command) Output() string {
-	var buf bytes.Buffer
-	f.BakeOpts(Opts{Out: &buf}).Run()
-	return buf.String()
+	out, err := f.OutputE()
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+/**
+ * Same as Output(), but returns errors instead of panicking, as RunE() does.
+ * Whatever output was accumulated is returned even if there is an error.
+ */
+func (f Command) OutputE() (string, error) {
+	capture, err := f.OutputCapture()
+	defer capture.Close()
+	return capture.String(), err
+}
+
+/**
+ * Same as OutputE(), but returns the iox.Capture the output was accumulated in,
+ * which says how much was dropped if Opts.Capture set a limit.  If the capture
+ * spilled to a file, it's up to the caller to Close() it.
+ *
+ * The error is only ever the command's, as from RunE().  If the spill file couldn't
+ * be written, that doesn't stop the command; check the capture's Err() for it.
+ */
+func (f Command) OutputCapture() (*iox.Capture, error) {
+	capture := f.expose().newCapture()
+	err := f.BakeOpts(Opts{Out: capture}).RunE()
+	return capture, err
+}
+
+func (cmdt *commandTemplate) newCapture() *iox.Capture {
+	if cmdt.Capture == nil {
+		return iox.NewCapture(iox.CaptureOpts{})
+	}
+	return iox.NewCapture(*cmdt.Capture)
 }
 
 /**
  * Same as Output(), but acts on both stdout and stderr.
  */
 func (f Command) CombinedOutput() string {
-	var buf bytes.Buffer
-	f.BakeOpts(Opts{Out: &buf, Err: &buf}).Run()
-	return buf.String()
+	out, err := f.CombinedOutputE()
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+/**
+ * Same as CombinedOutput(), but returns errors instead of panicking, as RunE() does.
+ * Whatever output was accumulated is returned even if there is an error.
+ */
+func (f Command) CombinedOutputE() (string, error) {
+	capture := f.expose().newCapture()
+	defer capture.Close()
+	err := f.BakeOpts(Opts{Out: capture, Err: capture}).RunE()
+	return capture.String(), err
 }
@@ -17,6 +17,9 @@ package gosh
 import (
 	"fmt"
 	"reflect"
+	"strings"
+	"syscall"
+	"time"
 )
 
 /**
@@ -55,10 +58,142 @@ func whoru(val reflect.Value) string {
  * but by default is any exit code other than zero.
  */
 type FailureExitCode struct {
-	cmdname string
-	code    int
+	/** The command, as given to Sh(). */
+	Cmdname string
+
+	/** The arguments the command was run with (not including the command itself). */
+	Args []string
+
+	/** The directory the command was run in. */
+	Cwd string
+
+	/** The exit code, following the 128+signal convention if the command was killed by a signal. */
+	Code int
+
+	/** The signal that terminated the command, or zero if it exited on its own. */
+	Signal syscall.Signal
+
+	/** How long the command ran. */
+	Duration time.Duration
+
+	/**
+	 * The last few kilobytes the command wrote to stderr, whether or not Opts.Err
+	 * was set.  This is left empty if stderr went to a file (including a terminal,
+	 * or a FileRedirect), to the same place as stdout, or if the command ran on a Tty.
+	 * Output from anything the command left running in the background isn't kept
+	 * once the command exits.
+	 */
+	StderrTail string
 }
 
 func (err FailureExitCode) Error() string {
-	return fmt.Sprintf("sh: command \"%s\" exited with unexpected status %d", err.cmdname, err.code)
+	msg := fmt.Sprintf("sh: command \"%s\" exited with unexpected status %d", err.Cmdname, err.Code)
+	if err.Signal != 0 {
+		msg += fmt.Sprintf(" (signal: %s)", err.Signal)
+	}
+	msg += fmt.Sprintf("\n\targs: %q\n\tcwd: %s\n\tran for: %s", err.Args, err.Cwd, err.Duration)
+	if err.StderrTail != "" {
+		msg += fmt.Sprintf("\n\tstderr (tail):\n%s", err.StderrTail)
+	}
+	return msg
+}
+
+/**
+ * Error for pipelines run by Sh in which one or more stages exited with a
+ * non-successful status.
+ *
+ * Which stages are checked is determined by the Opts.Pipefail policy of the
+ * last command in the pipeline.
+ */
+type PipelineFailure struct {
+	stages   []int
+	failures []FailureExitCode
+}
+
+/** Returns the indexes (counting from zero) of the stages that failed. */
+func (err PipelineFailure) FailedStages() []int {
+	return err.stages
+}
+
+func (err PipelineFailure) Error() string {
+	msgs := make([]string, len(err.stages))
+	for i, stage := range err.stages {
+		msgs[i] = fmt.Sprintf("stage %d (\"%s\") exited with unexpected status %d", stage, err.failures[i].Cmdname, err.failures[i].Code)
+	}
+	return fmt.Sprintf("sh: pipeline failed: %s", strings.Join(msgs, "; "))
+}
+
+/**
+ * Error for commands run by Sh that were stopped for exceeding one of their Opts.Rlimits.
+ *
+ * Only limits that the system enforces with a signal (i.e. CPU) can be detected this way;
+ * a command that runs out of, say, file descriptors sees ordinary errors, and may exit
+ * however it sees fit.
+ */
+type ResourceLimitExceeded struct {
+	/** Which limit was exceeded, e.g. "cpu". */
+	Resource string
+
+	/** The limit that was set. */
+	Limit uint64
+
+	FailureExitCode
+}
+
+func (err ResourceLimitExceeded) Error() string {
+	return fmt.Sprintf("sh: command \"%s\" exceeded its %s limit of %d\n%s", err.Cmdname, err.Resource, err.Limit, err.FailureExitCode.Error())
+}
+
+/**
+ * Returned when a command run in a cgroup (see Opts.Cgroup) fails after the OOM killer
+ * killed something in it.  The exit code is usually 137, but the OOM is the reason.
+ */
+type OutOfMemory struct {
+	/** The memory limit that was set, in bytes, or zero if the limit came from elsewhere. */
+	Limit uint64
+
+	/** How many processes the OOM killer killed. */
+	OomKills uint64
+
+	FailureExitCode
+}
+
+func (err OutOfMemory) Error() string {
+	limit := "its memory limit"
+	if err.Limit != 0 {
+		limit = fmt.Sprintf("its memory limit of %d bytes", err.Limit)
+	}
+	return fmt.Sprintf("sh: command \"%s\" ran out of memory: %d process(es) killed at %s\n%s", err.Cmdname, err.OomKills, limit, err.FailureExitCode.Error())
+}
+
+/**
+ * Returned when a command's output can't be decoded, by OutputJSON() or a RecordWriter.
+ * The command may well have exited successfully; its status is included regardless.
+ */
+type DecodeFailure struct {
+	/** What the output was being decoded as, e.g. "json" or "csv". */
+	Format string
+
+	/** Which record couldn't be decoded or handled, counting from 1; or 0 if the output was a single document. */
+	Record int
+
+	/** Some of the output around where decoding failed. */
+	Excerpt string
+
+	Cause error
+
+	FailureExitCode
+}
+
+func (err DecodeFailure) Error() string {
+	msg := fmt.Sprintf("sh: cannot decode output of command \"%s\" as %s", err.Cmdname, err.Format)
+	if err.Record != 0 {
+		msg += fmt.Sprintf(" at record %d", err.Record)
+	}
+	msg += fmt.Sprintf(": %s\n\texit status: %d", err.Cause, err.Code)
+	if err.Signal != 0 {
+		msg += fmt.Sprintf(" (signal: %s)", err.Signal)
+	}
+	msg += fmt.Sprintf("\n\targs: %q\n\tcwd: %s\n\toutput (excerpt): %q", err.Args, err.Cwd, err.Excerpt)
+	return msg
 }
@@ -15,7 +15,10 @@
 package gosh
 
 import (
+	"context"
 	"os"
+	"polydawn.net/pogo/iox"
+	"time"
 )
 
 type commandTemplate struct {
@@ -23,7 +26,9 @@ type commandTemplate struct {
 
 	args []string
 
-	env Env
+	env environment
+
+	ctx *Context
 
 	Opts
 }
@@ -40,6 +45,12 @@ type Opts struct {
 	 *   - <-chan string, in which case that will be streamed in
 	 *   - <-chan byte[], in which case that will be streamed in
 	 *   - another Command, in which case that will be started with this one and its output piped into this one
+	 *   - a FileRedirect, e.g. FromFile(path), in which case the command reads the file directly
+	 *   - iox.TemplateInput, in which case the template is rendered as the command reads it
+	 *   - iox.MultiInput, in which case each of the things in it will be streamed in, in turn
+	 *
+	 * When piping from another Command, the stages are connected with an OS pipe, the same as a shell
+	 * would; the upstream command's Out is replaced by the pipe (and so is its Err, if Err was the same as Out).
 	 */
 	In interface{}
 
@@ -47,8 +58,10 @@ type Opts struct {
 	 * Can be a:
 	 *   - bytes.Buffer, which will be written to literally
 	 *   - io.Writer, which will be written to streamingly, flushed to whenever the command flushes
-	 *   - chan<- string, which will be written to streamingly, flushed to whenever a line break occurs in the output
+	 *   - chan<- string, which will be sent each line of the output as a message, line break included, as
+	 *     soon as it's complete (and the last line when the command exits, if it doesn't end in a line break)
 	 *   - chan<- byte[], which will be written to streamingly, flushed to whenever the command flushes
+	 *   - a FileRedirect, e.g. ToFile(path, 0644) or DevNull, in which case the command writes the file directly
 	 *
 	 * (There's nothing that's quite the equivalent of how you can give In a string, sadly; since
 	 * strings are immutable in golang, you can't set Out=&str and get anywhere.)
@@ -65,14 +78,167 @@ type Opts struct {
 	 * (If this slice is provided, zero will -not- be considered a success code unless explicitly included.)
 	 */
 	OkExit []int
+
+	/**
+	 * If this command is the last stage of a pipeline, decides which stages' exit codes count
+	 * toward success.  If not provided, LAST_STAGE is the default.
+	 */
+	Pipefail PipefailPolicy
+
+	/**
+	 * Starts the command as the leader of a new process group, or of a new session.  Signals sent
+	 * through RunningCommand then reach every process in the group, and the command isn't considered
+	 * done until every process in the group has exited.  If not provided, INHERIT_GROUP is the default.
+	 *
+	 * (Processes that move themselves out of the group, e.g. daemons, are not tracked.
+	 * And since the group has to be polled, a long-lived group may be noticed to be gone
+	 * up to a second after its last process exits.)
+	 */
+	ProcessGroup ProcessGroupMode
+
+	/**
+	 * If provided, the command is stopped once it has run this long, and Run() reports a
+	 * TimeoutExceeded error.  If the command is the last stage of a pipeline, its Timeout also
+	 * applies to any stages that don't have one.
+	 */
+	Timeout time.Duration
+
+	/**
+	 * If provided, a command that exceeds its Timeout is sent SIGTERM, and then killed only if
+	 * it still hasn't exited after this much longer.  If not provided, it is killed outright.
+	 */
+	KillGrace time.Duration
+
+	/**
+	 * If provided, the command is run on a pseudo-terminal instead of being given In, Out, and Err
+	 * directly.  See Tty for how input and output are handled.
+	 */
+	Tty *Tty
+
+	/**
+	 * If provided, resource limits to apply to the command (and not to this process).
+	 * Note that this requires starting the command via /bin/sh; see Rlimits.
+	 */
+	Rlimits *Rlimits
+
+	/**
+	 * If provided, the user and groups to run the command as.
+	 */
+	Credential *Credential
+
+	/**
+	 * If provided, the umask to run the command with.  Use Umask() to fill this in.
+	 *
+	 * The umask is set by starting the command via /bin/sh, which must therefore exist
+	 * (under Isolation.Root, if that's set too).  Where /bin/sh doesn't support
+	 * `exec -a` (e.g. where it's dash), this changes the command's argv[0] from the
+	 * name it was given to the path it was found at.
+	 */
+	Umask *os.FileMode
+
+	/**
+	 * If provided, namespaces and a root directory to isolate the command in.
+	 */
+	Isolation *Isolation
+
+	/**
+	 * If provided, a cgroup v2 subtree to create for the command, with limits.
+	 */
+	Cgroup *Cgroup
+
+	/**
+	 * If provided, Output() and CombinedOutput() keep no more than this much of the output
+	 * in memory.  See iox.Capture.  Any spill file is removed once they return; use
+	 * OutputCapture() to get at it.
+	 */
+	Capture *iox.CaptureOpts
 }
 
+/**
+ * Whether a command gets a process group of its own.  Set as Opts.ProcessGroup.
+ *
+ * The zero value isn't a mode; it's what leaves Opts.ProcessGroup unset, so that
+ * INHERIT_GROUP can be set explicitly to override a mode baked in earlier.
+ */
+type ProcessGroupMode int
+
+const (
+	/** The command stays in our process group. */
+	INHERIT_GROUP ProcessGroupMode = iota + 1
+
+	/** The command is started in a new process group (as by setpgid). */
+	NEW_PROCESS_GROUP
+
+	/** The command is started in a new session, and thus also a new process group (as by setsid). */
+	NEW_SESSION
+)
+
 var DefaultIO = Opts{
 	In:  os.Stdin,
 	Out: os.Stdout,
 	Err: os.Stderr,
 }
 
+/**
+ * Sets environment variables for a command.  A variable set to the empty string is
+ * set, and empty; use Unset to remove a variable instead.
+ *
+ * Variables the command inherits keep their place in the environment, and new ones
+ * are added after them, in order of key.  See Command.Environ() for the result.
+ */
 type Env map[string]string
 
+/**
+ * A value for an Env entry that removes the variable from the environment.
+ * (It can't be mistaken for a real value; the environment can't contain NULs.)
+ */
+const Unset = "\x00unset"
+
 type ClearEnv struct{}
+
+/**
+ * Removes every environment variable except the ones listed.  Use InheritOnly() to
+ * make one, e.g. `InheritOnly("HOME", "PATH")`.
+ */
+type KeepEnv []string
+
+func InheritOnly(keys ...string) KeepEnv {
+	return KeepEnv(keys)
+}
+
+/**
+ * Like Env, but $VAR and ${VAR} references in the values are expanded against the
+ * command's environment as it was before this modifier; e.g.
+ *   `EnvExpand{"GOPATH": "$HOME/go"}`
+ * Variables that aren't set expand to the empty string.
+ */
+type EnvExpand map[string]string
+
+/**
+ * Adds a directory (or several, separated by colons) to the front of the command's PATH.
+ * Use it like `PrependPath("/opt/tool/bin")`.
+ */
+type PrependPath string
+
+/**
+ * Adds a directory (or several, separated by colons) to the end of the command's PATH.
+ */
+type AppendPath string
+
+/**
+ * Ties the lifetime of a command to a context.  When the context is done, the command
+ * is sent Signal, and if it hasn't exited after Grace has passed, it is killed.
+ *
+ * If Signal is nil, the command is killed outright.
+ *
+ * A command stopped this way ends in the CANCELLED state, and Run() reports it with
+ * a CommandCancelled error rather than FailureExitCode.  If the command is the last
+ * stage of a pipeline, its Context also applies to any stages that don't have one.
+ */
+type Context struct {
+	context.Context
+
+	Signal os.Signal
+
+	Grace time.Duration
+}
@@ -0,0 +1,191 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os"
+	"strings"
+)
+
+/** Returns the name of the command, as given to Sh(). */
+func (f Command) Name() string {
+	return f.expose().cmd
+}
+
+/** Returns the arguments baked into the command so far. */
+func (f Command) Args() []string {
+	return append([]string(nil), f.expose().args...)
+}
+
+/** Returns the options baked into the command so far. */
+func (f Command) Opts() Opts {
+	return f.expose().Opts
+}
+
+/**
+ * Returns how the command's environment differs from the environment of this process:
+ * every variable that's set differently or only for the command, and every variable
+ * that's removed for the command, with the value Unset.
+ */
+func (f Command) EnvDiff() Env {
+	set, unset := f.expose().envDiff()
+	diff := make(Env, len(set)+len(unset))
+	for _, entry := range set {
+		chunks := strings.SplitN(entry, "=", 2)
+		diff[chunks[0]] = chunks[1]
+	}
+	for _, key := range unset {
+		diff[key] = Unset
+	}
+	return diff
+}
+
+/**
+ * Returns the variables the command sets (as "KEY=value", in order) and the ones it
+ * removes, relative to the environment of this process.
+ */
+func (cmdt *commandTemplate) envDiff() ([]string, []string) {
+	osEnv := osEnvironment()
+	var set, unset []string
+	for _, entry := range cmdt.env {
+		if !containsString(osEnv, entry) {
+			set = append(set, entry)
+		}
+	}
+	for _, entry := range osEnv {
+		key := entry[:strings.Index(entry, "=")]
+		if cmdt.env.index(key) < 0 {
+			unset = append(unset, key)
+		}
+	}
+	return set, unset
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Renders the command as a line of bash that would run the same thing, quoted so it
+ * can be copied and pasted.  See ShellQuote().
+ */
+func (f Command) String() string {
+	return f.ShellQuote()
+}
+
+/**
+ * Renders the command as a line of bash that would run the same thing, e.g.
+ *   `cd /src && GOOS=linux go build -o 'my bin' ./...`
+ *
+ * The working directory becomes a cd, and differences from this process's environment
+ * become assignments in front of the command, or an `env -u` or `env -i` if variables
+ * are removed.  A pipeline (a command whose input is another Command) is rendered
+ * stage by stage, joined with pipes.  Redirections to and from files (see
+ * FileRedirect) are shown too, but other input and output aren't, nor are the rest of the Opts.
+ */
+func (f Command) ShellQuote() string {
+	cmdt := f.expose()
+	if upstream, ok := cmdt.In.(Command); ok {
+		line := upstream.ShellQuote()
+		if upstream.expose().Cwd != "" {
+			line = "(" + line + ")"
+		}
+		stage := cmdt.renderStage()
+		if cmdt.Cwd != "" {
+			stage = "(" + stage + ")"
+		}
+		return line + " | " + stage
+	}
+	return cmdt.renderStage()
+}
+
+func (cmdt *commandTemplate) renderStage() string {
+	var words []string
+	if cmdt.Cwd != "" {
+		words = append(words, "cd", shellQuoteWord(cmdt.Cwd), "&&")
+	}
+
+	set, unset := cmdt.envDiff()
+	if len(unset) > len(cmdt.env)-len(set) {
+		// more is removed than kept; easier to start from nothing.
+		words = append(words, "env", "-i")
+		set = cmdt.env
+	} else if len(unset) > 0 {
+		words = append(words, "env")
+		for _, key := range unset {
+			words = append(words, "-u", shellQuoteWord(key))
+		}
+	}
+	for _, entry := range set {
+		chunks := strings.SplitN(entry, "=", 2)
+		words = append(words, chunks[0]+"="+shellQuoteWord(chunks[1]))
+	}
+
+	words = append(words, shellQuoteWord(cmdt.cmd))
+	for _, arg := range cmdt.args {
+		words = append(words, shellQuoteWord(arg))
+	}
+
+	if in, ok := cmdt.In.(FileRedirect); ok {
+		words = append(words, "<", shellQuoteWord(in.Path))
+	}
+	if out, ok := cmdt.Out.(FileRedirect); ok {
+		words = append(words, redirectOperator("", out), shellQuoteWord(out.Path))
+	}
+	switch errOut := cmdt.Err.(type) {
+	case stderrToStdout:
+		words = append(words, "2>&1")
+	case FileRedirect:
+		if cmdt.Err == cmdt.Out {
+			words = append(words, "2>&1")
+		} else {
+			words = append(words, redirectOperator("2", errOut), shellQuoteWord(errOut.Path))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func redirectOperator(fd string, redirect FileRedirect) string {
+	if redirect.Flag&os.O_APPEND != 0 {
+		return fd + ">>"
+	}
+	return fd + ">"
+}
+
+/**
+ * Quotes a word for bash: left alone if it's only made of characters that are never
+ * special, and otherwise single-quoted, with any single quotes spliced in as \'.
+ */
+func shellQuoteWord(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_@%+=:,./-", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
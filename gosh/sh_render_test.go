@@ -0,0 +1,109 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"fmt"
+	"github.com/coocood/assrt"
+	"os"
+	"testing"
+)
+
+func TestCommandAccessors(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("echo")("a", "b")(Opts{Cwd: "/tmp", OkExit: []int{0, 1}})
+	assert.Equal(
+		"echo",
+		cmd.Name(),
+	)
+	assert.Equal(
+		[]string{"a", "b"},
+		cmd.Args(),
+	)
+	assert.Equal(
+		"/tmp",
+		cmd.Opts().Cwd,
+	)
+	assert.Equal(
+		[]int{0, 1},
+		cmd.Opts().OkExit,
+	)
+
+	// the args returned are a copy.
+	cmd.Args()[0] = "z"
+	assert.Equal(
+		"a",
+		cmd.Args()[0],
+	)
+}
+
+func TestCommandEnvDiff(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	os.Setenv("GOSH_TEST_DIFF", "x")
+	defer os.Unsetenv("GOSH_TEST_DIFF")
+	cmd := Sh("env")(Env{"GOSH_TEST_NEW": "1", "GOSH_TEST_DIFF": Unset})
+	assert.Equal(
+		Env{"GOSH_TEST_NEW": "1", "GOSH_TEST_DIFF": Unset},
+		cmd.EnvDiff(),
+	)
+	assert.Equal(
+		Env{},
+		Sh("env").EnvDiff(),
+	)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	assert.Equal(
+		"echo plain ./a/b.c 'two words' '' 'it'\\''s' '$HOME' 'a;b'",
+		Sh("echo")("plain", "./a/b.c", "two words", "", "it's", "$HOME", "a;b").ShellQuote(),
+	)
+	assert.Equal(
+		"cd '/tmp/my dir' && GOSH_A='x y' GOSH_B=2 go build",
+		Sh("go")("build")(Opts{Cwd: "/tmp/my dir"})(Env{"GOSH_B": "2", "GOSH_A": "x y"}).String(),
+	)
+	assert.Equal(
+		"env -i PATH=/bin ls",
+		Sh("ls")(ClearEnv{})(Env{"PATH": "/bin"}).String(),
+	)
+	assert.Equal(
+		"grep x | (cd /tmp && wc -l)",
+		fmt.Sprint(Sh("wc")("-l")(Opts{In: Sh("grep")("x"), Cwd: "/tmp"})),
+	)
+}
+
+func TestShellQuoteUnset(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	os.Setenv("GOSH_TEST_UNSET", "x")
+	defer os.Unsetenv("GOSH_TEST_UNSET")
+	assert.Equal(
+		"env -u GOSH_TEST_UNSET true",
+		Sh("true")(Env{"GOSH_TEST_UNSET": Unset}).String(),
+	)
+}
+
+func TestShellQuoteRoundTrip(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmd := Sh("sh")("-c", `printf '%s|' "$@" "$GOSH_Q"`, "-", "it's", "a \"b\" $c", "")(Env{"GOSH_Q": "\\n'"})
+	assert.Equal(
+		cmd.Output(),
+		Sh("bash")("-c", cmd.ShellQuote()).Output(),
+	)
+}
@@ -0,0 +1,109 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/**
+ * The shell that commands are started via, when they need something done in the child
+ * between fork and exec.
+ */
+const trampolineShell = "/bin/sh"
+
+/**
+ * What trampolineShell can do beyond what POSIX requires of it.
+ */
+type shellFeatures struct {
+	/** Whether `exec -a name` sets the argv[0] of the program exec'd (bash does; dash doesn't). */
+	execArgv0 bool
+
+	/** The ulimit option for the number of processes: -u in bash, -p in dash, or none. */
+	nprocFlag string
+}
+
+var (
+	trampolineFeatures     shellFeatures
+	trampolineFeaturesOnce sync.Once
+)
+
+/**
+ * Finds out what trampolineShell can do, the first time it's asked.
+ */
+func probeTrampolineShell() shellFeatures {
+	trampolineFeaturesOnce.Do(func() {
+		// (bash has a -p too, but it's the pipe size, so -u has to be tried first.)
+		out, _ := exec.Command(trampolineShell, "-c", `
+			(exec -a x true) 2>/dev/null && echo exec-a
+			if (ulimit -u) >/dev/null 2>&1; then echo nproc-u
+			elif (ulimit -p) >/dev/null 2>&1; then echo nproc-p
+			fi
+		`).Output()
+		for _, feature := range strings.Fields(string(out)) {
+			switch feature {
+			case "exec-a":
+				trampolineFeatures.execArgv0 = true
+			case "nproc-u":
+				trampolineFeatures.nprocFlag = "-u"
+			case "nproc-p":
+				trampolineFeatures.nprocFlag = "-p"
+			}
+		}
+	})
+	return trampolineFeatures
+}
+
+/**
+ * Rewrites the command to be started via trampolineShell, which runs the prelude (shell
+ * commands, each of which must succeed) and then execs the real command in its place.
+ * Returns a func that puts the command back as it was, which should be called once
+ * it's started.
+ *
+ * This is how we get things done in the child between fork and exec, which the go
+ * runtime has no hook for.  Doing them to this process around the fork instead isn't an
+ * option: anything else in this process that forks in the meantime would get them too.
+ *
+ * The command keeps its argv[0] if the shell supports `exec -a`; otherwise, it gets its
+ * resolved path as argv[0].  If the command can't be found, it's left alone, so that
+ * starting it fails as it would have anyway.
+ */
+func (cmd *RunningCommand) trampoline(prelude []string) func() {
+	rcmd := cmd.cmd
+	path, args := rcmd.Path, rcmd.Args
+	if rcmd.SysProcAttr == nil || rcmd.SysProcAttr.Chroot == "" {
+		// can't check for a command that's only there inside a chroot.
+		check := path
+		if !filepath.IsAbs(check) && rcmd.Dir != "" {
+			check = filepath.Join(rcmd.Dir, check)
+		}
+		if _, err := exec.LookPath(check); err != nil {
+			return func() {}
+		}
+	}
+	// $0 is the original argv[0], and "$@" is the path and the rest of the args.
+	script := strings.Join(append(prelude, `exec "$@"`), " && ")
+	if probeTrampolineShell().execArgv0 {
+		script = strings.Join(append(prelude, `exec -a "$0" "$@"`), " && ")
+	}
+	rcmd.Path = trampolineShell
+	rcmd.Args = append([]string{"sh", "-c", script, args[0], path}, args[1:]...)
+	return func() {
+		rcmd.Path, rcmd.Args = path, args
+	}
+}
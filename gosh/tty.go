@@ -0,0 +1,196 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/kr/pty"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/**
+ * Runs the command on a pseudo-terminal, so that it sees a tty on stdin, stdout, and
+ * stderr, and as its controlling terminal.  Set as Opts.Tty.
+ *
+ * Input from Opts.In is written into the terminal, and everything the command writes
+ * to the terminal is streamed out to Opts.Out; since there's only one terminal, stdout
+ * and stderr can't be told apart, and Opts.Err is not used.  As with any terminal,
+ * input is echoed back to the output unless the command turns echo off.
+ *
+ * The command is started in a new session, so it's also the leader of a process group
+ * (see Opts.ProcessGroup).
+ */
+type Tty struct {
+	/** Initial window size.  If zero, the size is left up to the system. */
+	Rows uint16
+	Cols uint16
+}
+
+/**
+ * Opens a pty and rewires the command's stdio onto it.  The command's original
+ * stdin and stdout are kept to be pumped through the pty by startTtyPumps()
+ * once the command is started.
+ */
+func (cmd *RunningCommand) attachTty() error {
+	master, slave, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	if cmd.tty.Rows != 0 || cmd.tty.Cols != 0 {
+		if err := setWinsize(master, cmd.tty.Rows, cmd.tty.Cols); err != nil {
+			master.Close()
+			slave.Close()
+			return err
+		}
+	}
+
+	// If stdio were files (e.g. pipes to other stages), our caller may close them as soon as the
+	// command starts, since normally only the child needs them.  We need them a while longer.
+	in, out := cmd.cmd.Stdin, cmd.cmd.Stdout
+	if f, ok := in.(*os.File); ok {
+		if in, err = dupFile(f); err != nil {
+			master.Close()
+			slave.Close()
+			return err
+		}
+	}
+	if f, ok := out.(*os.File); ok {
+		if out, err = dupFile(f); err != nil {
+			if f, ok := in.(*os.File); ok {
+				f.Close()
+			}
+			master.Close()
+			slave.Close()
+			return err
+		}
+	}
+
+	cmd.pty = master
+	cmd.ttyIn = in
+	cmd.ttyOut = out
+	cmd.closeAfterStart = append(cmd.closeAfterStart, slave)
+	cmd.cmd.Stdin = slave
+	cmd.cmd.Stdout = slave
+	cmd.cmd.Stderr = slave
+	attr := cmd.cmd.SysProcAttr
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+		cmd.cmd.SysProcAttr = attr
+	}
+	attr.Setsid = true
+	attr.Setctty = true
+	attr.Ctty = 0
+	return nil
+}
+
+/**
+ * Starts copying input into the pty and output out of it.  Output is considered
+ * finished when nothing has the terminal open anymore; ttyDone closes then.
+ *
+ * The pty itself must not be closed until the command has been reaped: closing it
+ * hangs up the terminal, and a command that's only just closed its descriptors may
+ * still be around to catch the SIGHUP.
+ */
+func (cmd *RunningCommand) startTtyPumps() {
+	if cmd.ttyIn != nil {
+		go func() {
+			last, _ := copyTracked(cmd.pty, cmd.ttyIn)
+			// a terminal doesn't have a way to close only the input side; the closest thing is an EOT.
+			// it only ends input at the start of a line, so send a second one if it didn't land there.
+			if last != '\n' && last != 0 {
+				cmd.pty.Write([]byte{4})
+			}
+			cmd.pty.Write([]byte{4})
+			if f, ok := cmd.ttyIn.(*os.File); ok {
+				f.Close()
+			}
+		}()
+	}
+	cmd.ttyDone = make(chan bool)
+	go func() {
+		out := cmd.ttyOut
+		if out == nil {
+			out = ioutil.Discard
+		}
+		// reading the pty ends with EIO once the other side is closed by everyone; that's not an error.
+		io.Copy(out, cmd.pty)
+		if f, ok := cmd.ttyOut.(*os.File); ok {
+			f.Close()
+		}
+		close(cmd.ttyDone)
+	}()
+}
+
+/**
+ * Sets the window size of the command's terminal, which also sends it SIGWINCH.
+ *
+ * Returns a NotATty error if the command wasn't started with Opts.Tty.
+ */
+func (cmd *RunningCommand) Resize(rows, cols uint16) error {
+	if cmd.pty == nil {
+		return NotATty{}
+	}
+	return setWinsize(cmd.pty, rows, cols)
+}
+
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+func setWinsize(f *os.File, rows, cols uint16) error {
+	ws := winsize{rows: rows, cols: cols}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+/**
+ * Returns a duplicate of the file that stays open even if the original is closed.
+ */
+func dupFile(f *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+/**
+ * Same as io.Copy, but also returns the last byte copied (or zero if nothing was).
+ */
+func copyTracked(dst io.Writer, src io.Reader) (byte, error) {
+	var last byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			last = buf[n-1]
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return last, err
+			}
+		}
+		if err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return last, err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"syscall"
+	"time"
+)
+
+/**
+ * Resources used by a command, as reported by the system when the command was reaped.
+ *
+ * These figures cover the process itself and any of its descendants that it waited
+ * for; descendants it abandoned aren't counted.
+ */
+type ResourceUsage struct {
+	/** CPU time spent in user mode. */
+	UserTime time.Duration
+
+	/** CPU time spent in the kernel on the command's behalf. */
+	SystemTime time.Duration
+
+	/** Peak resident set size, in bytes. */
+	MaxRSS int64
+
+	/** Page faults serviced without any I/O. */
+	MinorFaults int64
+
+	/** Page faults that required I/O. */
+	MajorFaults int64
+
+	/** Times the command gave up the CPU voluntarily, typically to wait for I/O. */
+	VoluntaryContextSwitches int64
+
+	/** Times the command was preempted. */
+	InvoluntaryContextSwitches int64
+
+	/** How long the command ran, from when it was started until it was reaped. */
+	WallTime time.Duration
+}
+
+/**
+ * Waits for the command to exit if it has not already, then returns the resources
+ * it used.  Returns nil if the command's exit was never observed (e.g. it failed to
+ * start), or if the platform doesn't report usage.
+ */
+func (cmd *RunningCommand) GetUsage() *ResourceUsage {
+	if !cmd.IsDone() {
+		cmd.Wait()
+	}
+	if cmd.processState == nil {
+		return nil
+	}
+	rusage, ok := cmd.processState.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return nil
+	}
+	// linux reports maxrss in kilobytes.
+	return &ResourceUsage{
+		UserTime:                   time.Duration(rusage.Utime.Nano()),
+		SystemTime:                 time.Duration(rusage.Stime.Nano()),
+		MaxRSS:                     int64(rusage.Maxrss) * 1024,
+		MinorFaults:                int64(rusage.Minflt),
+		MajorFaults:                int64(rusage.Majflt),
+		VoluntaryContextSwitches:   int64(rusage.Nvcsw),
+		InvoluntaryContextSwitches: int64(rusage.Nivcsw),
+		WallTime:                   cmd.reapTime.Sub(cmd.startTime),
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gosh
+
+import (
+	"github.com/coocood/assrt"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestUsageReported(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("bash", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done; sleep 0.2"),
+	)
+	cmdr.Start()
+	usage := cmdr.GetUsage()
+	assert.Equal(
+		true,
+		usage.UserTime+usage.SystemTime > 0,
+	)
+	assert.Equal(
+		true,
+		usage.MaxRSS > 0,
+	)
+	assert.Equal(
+		true,
+		usage.MinorFaults > 0,
+	)
+	assert.Equal(
+		true,
+		usage.WallTime >= 200*time.Millisecond,
+	)
+}
+
+func TestUsageNotReportedIfNeverStarted(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	cmdr := NewRunningCommand(
+		exec.Command("/thishadbetternotbeacommand"),
+	)
+	cmdr.startCalmly()
+	assert.Equal(
+		(*ResourceUsage)(nil),
+		cmdr.GetUsage(),
+	)
+}
@@ -0,0 +1,295 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+/*
+	Which part of the output a Capture keeps, once there's more than its limit.
+*/
+type CaptureMode int
+
+const (
+	/* Keep the first Limit bytes. */
+	KEEP_HEAD CaptureMode = iota
+
+	/* Keep the last Limit bytes. */
+	KEEP_TAIL
+
+	/* Keep the first half of Limit bytes and the last half. */
+	KEEP_HEAD_AND_TAIL
+)
+
+/*
+	Configuration for a Capture.
+*/
+type CaptureOpts struct {
+	/* How many bytes to keep in memory.  Zero or less keeps everything. */
+	Limit int
+
+	Mode CaptureMode
+
+	/*
+		If set, once there's more output than Limit, all of it (from the
+		beginning) is also written to a temp file, so nothing is lost.
+		The caller is responsible for removing it; see Capture.Close().
+		If the file can't be written, there's no spill; see Capture.Err().
+	*/
+	Spill bool
+
+	/* Where to create the spill file.  If empty, the default temp dir. */
+	SpillDir string
+}
+
+/*
+	A writer that keeps a bounded amount of what's written to it in memory,
+	and keeps count of what it didn't.
+*/
+type Capture struct {
+	opts CaptureOpts
+
+	mutex sync.Mutex
+	head  []byte
+
+	/* A ring: once full, the oldest byte is at tailPos. */
+	tail     []byte
+	tailPos  int
+	tailFull bool
+
+	total int64
+	spill *os.File
+	err   error
+}
+
+func NewCapture(opts CaptureOpts) *Capture {
+	c := &Capture{opts: opts}
+	switch {
+	case opts.Limit <= 0:
+		c.opts.Mode = KEEP_HEAD
+	case opts.Mode == KEEP_TAIL:
+		c.tail = make([]byte, 0, opts.Limit)
+	case opts.Mode == KEEP_HEAD_AND_TAIL:
+		c.tail = make([]byte, 0, opts.Limit-opts.Limit/2)
+	}
+	return c
+}
+
+func (c *Capture) headLimit() int {
+	switch {
+	case c.opts.Limit <= 0:
+		return -1
+	case c.opts.Mode == KEEP_TAIL:
+		return 0
+	case c.opts.Mode == KEEP_HEAD_AND_TAIL:
+		return c.opts.Limit / 2
+	default:
+		return c.opts.Limit
+	}
+}
+
+/*
+	Always accepts everything, and never returns an error.  If spilling to the
+	file fails, the spill file is removed and the capture in memory carries on
+	regardless; see Err().
+*/
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.opts.Spill && c.spill == nil && c.err == nil && c.opts.Limit > 0 && c.total+int64(len(p)) > int64(c.opts.Limit) {
+		c.startSpill()
+	}
+	if c.spill != nil {
+		if _, err := c.spill.Write(p); err != nil {
+			c.abandonSpill(err)
+		}
+	}
+	c.total += int64(len(p))
+
+	rest := p
+	if limit := c.headLimit(); limit < 0 || len(c.head) < limit {
+		n := len(rest)
+		if limit >= 0 && n > limit-len(c.head) {
+			n = limit - len(c.head)
+		}
+		c.head = append(c.head, rest[:n]...)
+		rest = rest[n:]
+	}
+	if cap(c.tail) > 0 {
+		c.writeTail(rest)
+	}
+
+	return len(p), nil
+}
+
+func (c *Capture) writeTail(p []byte) {
+	size := cap(c.tail)
+	if len(p) >= size {
+		c.tail = append(c.tail[:0], p[len(p)-size:]...)
+		c.tailPos, c.tailFull = 0, true
+		return
+	}
+	if !c.tailFull {
+		room := size - len(c.tail)
+		if len(p) <= room {
+			c.tail = append(c.tail, p...)
+			c.tailFull = len(c.tail) == size
+			return
+		}
+		c.tail = append(c.tail, p[:room]...)
+		p = p[room:]
+		c.tailFull = true
+	}
+	for len(p) > 0 {
+		n := copy(c.tail[c.tailPos:], p)
+		p = p[n:]
+		c.tailPos = (c.tailPos + n) % size
+	}
+}
+
+/* Writes what's been captured so far, when the spill starts.  Must hold the mutex. */
+func (c *Capture) startSpill() {
+	f, err := ioutil.TempFile(c.opts.SpillDir, "iox-capture-")
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.spill = f
+	// nothing's been dropped yet, so the head and tail are everything so far.
+	if _, err := f.Write(c.head); err != nil {
+		c.abandonSpill(err)
+		return
+	}
+	if _, err := f.Write(c.tailBytes()); err != nil {
+		c.abandonSpill(err)
+	}
+}
+
+/* Removes a spill file that's missing some of the output.  Must hold the mutex. */
+func (c *Capture) abandonSpill(err error) {
+	c.err = err
+	c.spill.Close()
+	os.Remove(c.spill.Name())
+	c.spill = nil
+}
+
+func (c *Capture) tailBytes() []byte {
+	if !c.tailFull || c.tailPos == 0 {
+		return c.tail
+	}
+	out := make([]byte, 0, len(c.tail))
+	out = append(out, c.tail[c.tailPos:]...)
+	return append(out, c.tail[:c.tailPos]...)
+}
+
+/*
+	Returns what was kept: the head, the tail, or the head followed directly
+	by the tail.  See String() for something more readable.
+*/
+func (c *Capture) Bytes() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	out := make([]byte, 0, len(c.head)+len(c.tail))
+	out = append(out, c.head...)
+	return append(out, c.tailBytes()...)
+}
+
+/*
+	Returns what was kept, as a string.  In KEEP_HEAD_AND_TAIL mode, if anything
+	was dropped, a line saying how much is put between the head and the tail.
+*/
+func (c *Capture) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	dropped := c.dropped()
+	if c.opts.Mode != KEEP_HEAD_AND_TAIL || dropped == 0 {
+		return string(c.head) + string(c.tailBytes())
+	}
+	return fmt.Sprintf("%s\n[... %d bytes dropped ...]\n%s", c.head, dropped, c.tailBytes())
+}
+
+/* Returns how many bytes were written in total. */
+func (c *Capture) Total() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.total
+}
+
+/* Returns how many bytes were written but not kept in memory. */
+func (c *Capture) Dropped() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dropped()
+}
+
+func (c *Capture) dropped() int64 {
+	return c.total - int64(len(c.head)) - int64(len(c.tail))
+}
+
+/*
+	Returns the error that stopped the output from being spilled to a file,
+	or nil.  Once there's been an error, there's no spill file.
+*/
+func (c *Capture) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
+
+/* Returns the path of the spill file, or "" if nothing was spilled. */
+func (c *Capture) SpillPath() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.spill == nil {
+		return ""
+	}
+	return c.spill.Name()
+}
+
+/*
+	Returns a reader of everything that was written: from the spill file if
+	there is one, and otherwise from memory (which is only everything if
+	nothing was dropped).
+*/
+func (c *Capture) Open() (io.ReadCloser, error) {
+	path := c.SpillPath()
+	if path == "" {
+		return ioutil.NopCloser(bytes.NewReader(c.Bytes())), nil
+	}
+	return os.Open(path)
+}
+
+/*
+	Closes and removes the spill file, if there is one.  After that, Open()
+	only has what was kept in memory.
+*/
+func (c *Capture) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.spill == nil {
+		return nil
+	}
+	c.spill.Close()
+	err := os.Remove(c.spill.Name())
+	c.spill = nil
+	return err
+}
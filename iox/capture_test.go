@@ -0,0 +1,148 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"github.com/coocood/assrt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func capture(opts CaptureOpts, writes ...string) *Capture {
+	c := NewCapture(opts)
+	for _, s := range writes {
+		c.Write([]byte(s))
+	}
+	return c
+}
+
+func TestCaptureUnlimited(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	c := capture(CaptureOpts{}, "asdf", "", "\nwakawaka")
+	assert.Equal(
+		"asdf\nwakawaka",
+		c.String(),
+	)
+	assert.Equal(int64(13), c.Total())
+	assert.Equal(int64(0), c.Dropped())
+}
+
+func TestCaptureKeepHead(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	c := capture(CaptureOpts{Limit: 5}, "abc", "defg", "hij")
+	assert.Equal(
+		"abcde",
+		c.String(),
+	)
+	assert.Equal(int64(5), c.Dropped())
+}
+
+func TestCaptureKeepTail(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	for writes, expect := range map[string]string{
+		"abc":        "abc",
+		"abcdefghij": "fghij",
+	} {
+		var chunks []string
+		for _, c := range writes {
+			chunks = append(chunks, string(c))
+		}
+		// the same whether written all at once or a byte at a time.
+		assert.Equal(
+			expect,
+			capture(CaptureOpts{Limit: 5, Mode: KEEP_TAIL}, writes).String(),
+		)
+		assert.Equal(
+			expect,
+			capture(CaptureOpts{Limit: 5, Mode: KEEP_TAIL}, chunks...).String(),
+		)
+	}
+	c := capture(CaptureOpts{Limit: 5, Mode: KEEP_TAIL}, "abc", "defg", "hi", "jklmnopq", "rs")
+	assert.Equal(
+		"opqrs",
+		c.String(),
+	)
+	assert.Equal(int64(14), c.Dropped())
+}
+
+func TestCaptureKeepHeadAndTail(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	c := capture(CaptureOpts{Limit: 6, Mode: KEEP_HEAD_AND_TAIL}, "abcd")
+	assert.Equal(
+		"abcd",
+		c.String(),
+	)
+
+	c = capture(CaptureOpts{Limit: 6, Mode: KEEP_HEAD_AND_TAIL}, "ab", "cdefgh", "ijkl")
+	assert.Equal(
+		"abcjkl",
+		string(c.Bytes()),
+	)
+	assert.Equal(
+		"abc\n[... 6 bytes dropped ...]\njkl",
+		c.String(),
+	)
+	assert.Equal(int64(6), c.Dropped())
+}
+
+func TestCaptureSpill(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	dir, err := ioutil.TempDir("", "iox-capture-test-")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	c := capture(CaptureOpts{Limit: 4, Mode: KEEP_TAIL, Spill: true, SpillDir: dir}, "abc")
+	assert.Equal("", c.SpillPath())
+
+	c.Write([]byte("defg"))
+	c.Write([]byte("hij"))
+	assert.Equal("ghij", c.String())
+	assert.Equal(int64(6), c.Dropped())
+	path := c.SpillPath()
+	assert.NotEqual("", path)
+
+	r, err := c.Open()
+	assert.Nil(err)
+	all, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(
+		"abcdefghij",
+		string(all),
+	)
+
+	assert.Nil(c.Close())
+	_, err = os.Stat(path)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestCaptureSpillFailure(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	c := NewCapture(CaptureOpts{Limit: 4, Mode: KEEP_TAIL, Spill: true, SpillDir: "/nonexistent"})
+	for _, s := range []string{"abc", "defg", "hij"} {
+		n, err := c.Write([]byte(s))
+		assert.Equal(len(s), n)
+		assert.Nil(err)
+	}
+	assert.Equal("ghij", c.String())
+	assert.Equal("", c.SpillPath())
+	assert.NotNil(c.Err())
+}
@@ -0,0 +1,112 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"io"
+	"text/template"
+)
+
+/*
+	A text/template and the data to execute it with, as a source of input.
+
+	Nothing is rendered until the first Read, and from then on the template is
+	rendered only as fast as it's read.  If executing the template fails, the
+	Read that gets that far returns the error.
+*/
+type TemplateInput struct {
+	Template *template.Template
+	Data     interface{}
+}
+
+/*
+	Several sources of input, read one after the other.  Each can be anything
+	ReaderFromInterface accepts (including another MultiInput).
+*/
+type MultiInput []interface{}
+
+/*
+	Produces a reader of the rendered template.
+
+	The result is also an io.Closer.  Closing it stops the rendering if the rest
+	of the output isn't going to be read; otherwise the template's execution is
+	left blocked waiting for a reader forever.
+*/
+func ReaderFromTemplate(tmpl *template.Template, data interface{}) io.ReadCloser {
+	return &readerTemplate{tmpl: tmpl, data: data}
+}
+
+type readerTemplate struct {
+	tmpl *template.Template
+	data interface{}
+	pr   *io.PipeReader
+}
+
+func (r *readerTemplate) start() {
+	pr, pw := io.Pipe()
+	r.pr = pr
+	go func() {
+		pw.CloseWithError(r.tmpl.Execute(pw, r.data))
+	}()
+}
+
+func (r *readerTemplate) Read(p []byte) (n int, err error) {
+	if r.pr == nil {
+		r.start()
+	}
+	return r.pr.Read(p)
+}
+
+func (r *readerTemplate) Close() error {
+	if r.pr == nil {
+		// never started; leave it that way.
+		r.pr, _ = io.Pipe()
+	}
+	return r.pr.Close()
+}
+
+/*
+	Produces a reader that reads each of the sources in turn.  The sources are
+	refined as by ReaderFromInterface, immediately, so an unusable source panics
+	here rather than partway through reading.
+
+	The result is also an io.Closer.  Closing it closes any templates among the
+	sources, but not the other sources, which belong to the caller.
+*/
+func ReaderFromMulti(xs ...interface{}) io.ReadCloser {
+	r := &readerMulti{}
+	readers := make([]io.Reader, len(xs))
+	for i, x := range xs {
+		readers[i] = ReaderFromInterface(x)
+		switch x.(type) {
+		case TemplateInput, MultiInput:
+			r.closers = append(r.closers, readers[i].(io.Closer))
+		}
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r
+}
+
+type readerMulti struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readerMulti) Close() error {
+	for _, c := range r.closers {
+		c.Close()
+	}
+	return nil
+}
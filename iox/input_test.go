@@ -0,0 +1,97 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"bytes"
+	"github.com/coocood/assrt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestReaderFromTemplate(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	rendered := false
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"mark": func() string {
+			rendered = true
+			return ""
+		},
+	}).Parse("{{mark}}hello, {{.}}"))
+	r := ReaderFromInterface(TemplateInput{Template: tmpl, Data: "world"})
+	assert.False(rendered)
+
+	var output bytes.Buffer
+	io.Copy(&output, r)
+	assert.True(rendered)
+	assert.Equal(
+		"hello, world",
+		output.String(),
+	)
+}
+
+func TestReaderFromTemplateError(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	tmpl := template.Must(template.New("t").Parse("before {{.Missing}}"))
+	_, err := ioutil.ReadAll(ReaderFromTemplate(tmpl, "not a struct"))
+	assert.NotNil(err)
+}
+
+func TestReaderFromTemplateClose(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	tmpl := template.Must(template.New("").Parse("{{range .}}{{.}}{{end}}"))
+	r := ReaderFromTemplate(tmpl, make([]int, 100000))
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	assert.Nil(err)
+	assert.Equal(
+		"0",
+		string(buf[:n]),
+	)
+	r.Close()
+	_, err = r.Read(buf)
+	assert.Equal(
+		io.ErrClosedPipe,
+		err,
+	)
+}
+
+func TestReaderFromMulti(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ch := make(chan []byte, 1)
+	ch <- []byte("c")
+	close(ch)
+	tmpl := template.Must(template.New("").Parse("{{.}}"))
+	var output bytes.Buffer
+	io.Copy(&output, ReaderFromInterface(MultiInput{
+		"a",
+		[]byte("b"),
+		ch,
+		strings.NewReader("d"),
+		TemplateInput{Template: tmpl, Data: "e"},
+		MultiInput{"f", "g"},
+	}))
+	assert.Equal(
+		"abcdefg",
+		output.String(),
+	)
+}
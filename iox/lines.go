@@ -0,0 +1,127 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+/*
+	A writer that reframes whatever is written to it into lines, regardless of
+	how the writes were chunked, and hands each line on as a whole.
+
+	Each line is emitted with its delimiter, so the lines concatenated are exactly
+	what was written.  A line longer than MaxLength (not counting the delimiter)
+	is emitted in pieces of MaxLength, if MaxLength is set.  A partial line at the
+	end is held until more is written, or until Flush or Close.
+
+	Set Delimiter and MaxLength before the first Write.  It's safe to Write from
+	several goroutines; each line is emitted whole regardless.
+*/
+type LineWriter struct {
+	/* The byte that ends a line.  '\n' unless changed. */
+	Delimiter byte
+
+	/* The longest a line may be before it's split up.  Zero is no limit. */
+	MaxLength int
+
+	mutex sync.Mutex
+	emit  func(line string) error
+	close func() error
+	buf   []byte
+}
+
+/*
+	Produces a LineWriter that calls emit with each line.  If emit returns an
+	error, the Write that produced the line returns it too.
+*/
+func NewLineWriter(emit func(line string) error) *LineWriter {
+	return &LineWriter{Delimiter: '\n', emit: emit}
+}
+
+/*
+	Produces a LineWriter that sends each line to a channel as a message.
+
+	Closing the writer flushes any partial line and then closes the channel.
+	If the channel is closed by someone else, writes return io.EOF.
+*/
+func WriterToChanLines(ch chan<- string) *LineWriter {
+	w := NewLineWriter(func(line string) (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = io.EOF
+			}
+		}()
+		ch <- line
+		return nil
+	})
+	w.close = func() error {
+		close(ch)
+		return nil
+	}
+	return w
+}
+
+func (w *LineWriter) Write(p []byte) (n int, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		end := bytes.IndexByte(w.buf, w.Delimiter) + 1
+		if w.MaxLength > 0 && (end == 0 && len(w.buf) > w.MaxLength || end > w.MaxLength+1) {
+			end = w.MaxLength
+		}
+		if end == 0 {
+			return len(p), nil
+		}
+		line := string(w.buf[:end])
+		w.buf = w.buf[end:]
+		if err := w.emit(line); err != nil {
+			return 0, err
+		}
+	}
+}
+
+/*
+	Emits whatever partial line is being held, if any.
+*/
+func (w *LineWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := string(w.buf)
+	w.buf = nil
+	return w.emit(line)
+}
+
+/*
+	Flushes, and then closes whatever the lines were going to, if that's
+	something this writer made (see WriterToChanLines).
+*/
+func (w *LineWriter) Close() error {
+	err := w.Flush()
+	if w.close != nil {
+		if closeErr := w.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Eric Myhre
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iox
+
+import (
+	"github.com/coocood/assrt"
+	"io"
+	"testing"
+)
+
+func collectLines(w *LineWriter, writes ...string) []string {
+	var lines []string
+	w.emit = func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}
+	for _, s := range writes {
+		w.Write([]byte(s))
+	}
+	w.Flush()
+	return lines
+}
+
+func TestLineWriterFraming(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	assert.Equal(
+		[]string{"asdf\n", "wakawaka\n", "\n", "tz"},
+		collectLines(NewLineWriter(nil), "as", "df\nwaka", "", "waka\n\ntz"),
+	)
+}
+
+func TestLineWriterDelimiter(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	w := NewLineWriter(nil)
+	w.Delimiter = 0
+	assert.Equal(
+		[]string{"a\nb\x00", "c\x00"},
+		collectLines(w, "a\nb\x00c\x00"),
+	)
+}
+
+func TestLineWriterMaxLength(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	w := NewLineWriter(nil)
+	w.MaxLength = 3
+	assert.Equal(
+		[]string{"abc\n", "abc", "d\n", "abc", "def", "g"},
+		collectLines(w, "abc\nab", "cd\nabcdefg"),
+	)
+}
+
+func TestWriterToChanLines(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ch := make(chan string)
+	w := WriterToChanLines(ch)
+	go func() {
+		w.Write([]byte("asdf"))
+		w.Write([]byte(""))
+		w.Write([]byte("\nwakawaka"))
+		w.Write([]byte("\tz"))
+		w.Close()
+	}()
+
+	assert.Equal("asdf\n", <-ch)
+	assert.Equal("wakawaka\tz", <-ch)
+	_, open := <-ch
+	assert.Equal(false, open)
+}
+
+func TestWriterToChanLinesClosed(t *testing.T) {
+	assert := assrt.NewAssert(t)
+
+	ch := make(chan string)
+	close(ch)
+	_, err := WriterToChanLines(ch).Write([]byte("asdf\n"))
+	assert.Equal(
+		io.EOF,
+		err,
+	)
+}
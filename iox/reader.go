@@ -34,6 +34,8 @@ import (
 	ReadClosers will be produced from:
 		chan string
 		chan []byte
+		TemplateInput
+		MultiInput
 
 	An error of type ReaderUnrefinableFromInterface is thrown if an argument
 	of any other type is given.
@@ -56,6 +58,10 @@ func ReaderFromInterface(x interface{}) io.Reader {
 		return ReaderFromChanReadonlyByteSlice(y)
 	case chan []byte:
 		return ReaderFromChanByteSlice(y)
+	case TemplateInput:
+		return ReaderFromTemplate(y.Template, y.Data)
+	case MultiInput:
+		return ReaderFromMulti(y...)
 	default:
 		panic(ReaderUnrefinableFromInterface{wat: y})
 	}